@@ -18,7 +18,10 @@ import (
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/cloudprovider"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/controllers"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/webhooks"
 
+	"github.com/samber/lo"
 	"sigs.k8s.io/karpenter/pkg/cloudprovider/metrics"
 	corecontrollers "sigs.k8s.io/karpenter/pkg/controllers"
 	"sigs.k8s.io/karpenter/pkg/controllers/state"
@@ -38,6 +41,10 @@ func main() {
 	cloudProvider := metrics.Decorate(cloudstackProvider)
 	clusterState := state.NewCluster(op.Clock, op.GetClient(), cloudProvider)
 
+	if options.FromContext(ctx).WebhookEnabled {
+		lo.Must0(webhooks.Register(ctx, op.Manager, op.ZoneProvider, op.TemplateProvider, op.DiskOfferingProvider, op.SSHKeyPairProvider, op.UserDataProvider))
+	}
+
 	op.
 		WithControllers(ctx, corecontrollers.NewControllers(
 			ctx,
@@ -55,9 +62,17 @@ func main() {
 			op.Manager,
 			op.GetClient(),
 			op.EventRecorder,
+			op.CloudStackClient,
 			op.ZoneProvider,
 			op.NetworkProvider,
 			op.TemplateProvider,
+			op.InstanceTypeProvider,
+			op.InstanceProvider,
+			op.DiskOfferingProvider,
+			op.SSHKeyPairProvider,
+			op.BootstrapTokenProvider,
+			op.UserDataProvider,
+			op.VersionProvider,
 		)...).
 		Start(ctx)
 }
@@ -25,6 +25,10 @@ import (
 
 // CloudStackNodeClassSpec is the top level specification for the CloudStack Karpenter Provider.
 // This will contain configuration necessary to launch instances in CloudStack.
+// +kubebuilder:validation:XValidation:message="dataDiskSize requires diskOffering",rule="!has(self.dataDiskSize) || has(self.diskOffering)"
+// +kubebuilder:validation:XValidation:message="dataDiskMinIOPS and dataDiskMaxIOPS require diskOffering",rule="!(has(self.dataDiskMinIOPS) || has(self.dataDiskMaxIOPS)) || has(self.diskOffering)"
+// +kubebuilder:validation:XValidation:message="dataDiskMinIOPS must be less than or equal to dataDiskMaxIOPS",rule="!(has(self.dataDiskMinIOPS) && has(self.dataDiskMaxIOPS)) || self.dataDiskMinIOPS <= self.dataDiskMaxIOPS"
+// +kubebuilder:validation:XValidation:message="userData and userDataFrom are mutually exclusive",rule="!(has(self.userData) && has(self.userDataFrom))"
 type CloudStackNodeClassSpec struct {
 	// Zone is the CloudStack zone where VMs will be launched
 	// +kubebuilder:validation:Required
@@ -47,7 +51,7 @@ type CloudStackNodeClassSpec struct {
 
 	// TemplateSelectorTerms is a list of template selector terms. The terms are ORed.
 	// +kubebuilder:validation:XValidation:message="templateSelectorTerms cannot be empty",rule="self.size() != 0"
-	// +kubebuilder:validation:XValidation:message="expected at least one, got none, ['tags', 'id', 'name']",rule="self.all(x, has(x.tags) || has(x.id) || has(x.name))"
+	// +kubebuilder:validation:XValidation:message="expected at least one, got none, ['tags', 'id', 'name', 'kubernetesVersion']",rule="self.all(x, has(x.tags) || has(x.id) || has(x.name) || has(x.kubernetesVersion))"
 	// +kubebuilder:validation:MaxItems:=30
 	// +required
 	TemplateSelectorTerms []TemplateSelectorTerm `json:"templateSelectorTerms"`
@@ -57,13 +61,43 @@ type CloudStackNodeClassSpec struct {
 	// +optional
 	UserData *string `json:"userData,omitempty"`
 
-	// Tags to be applied on CloudStack resources like instances.
+	// UserDataFrom references a Secret or ConfigMap key holding the user data, instead of the inline UserData.
+	// Updating the referenced content drifts the nodes launched from the NodeClass.
+	// +optional
+	UserDataFrom *UserDataSource `json:"userDataFrom,omitempty"`
+
+	// UserDataCompression compresses the user data before it's base64-encoded, to fit larger
+	// cloud-init payloads within the CloudStack user data limit. cloud-init decompresses gzip
+	// user data transparently.
+	// +kubebuilder:validation:Enum:={None,Gzip}
+	// +optional
+	UserDataCompression *string `json:"userDataCompression,omitempty"`
+
+	// BootstrapMode selects how the node user data is generated. Custom, the default, passes
+	// UserData through as is. Kubeadm, K3s and RKE2 generate cloud-init that joins the node to
+	// the cluster with the NodeClaim labels, taints and kubelet configuration, and merge UserData
+	// in as an additional MIME part.
+	// +kubebuilder:validation:Enum:={Custom,Kubeadm,K3s,RKE2}
+	// +optional
+	BootstrapMode *string `json:"bootstrapMode,omitempty"`
+
+	// Bootstrap configures how nodes join the cluster when the user data is generated from a BootstrapMode
+	// +optional
+	Bootstrap *BootstrapConfiguration `json:"bootstrap,omitempty"`
+
+	// Kubelet defines args to be used when configuring kubelet on provisioned nodes.
+	// They are rendered into the node user data and used to compute the node allocatable.
+	// +optional
+	Kubelet *KubeletConfiguration `json:"kubelet,omitempty"`
+
+	// Tags to be applied on CloudStack resources like instances. Tags aren't hashed: changing them updates
+	// the tags of the existing instances in place instead of drifting them.
 	// +kubebuilder:validation:XValidation:message="empty tag keys aren't supported",rule="self.all(k, k != '')"
 	// +kubebuilder:validation:XValidation:message="tag contains a restricted tag matching karpenter.sh/nodepool",rule="self.all(k, k != 'karpenter.sh/nodepool')"
 	// +kubebuilder:validation:XValidation:message="tag contains a restricted tag matching karpenter.sh/nodeclaim",rule="self.all(k, k !='karpenter.sh/nodeclaim')"
 	// +kubebuilder:validation:XValidation:message="tag contains a restricted tag matching karpenter.k8s.cloudstack/nodeclass",rule="self.all(k, k !='karpenter.k8s.cloudstack/nodeclass')"
 	// +optional
-	Tags map[string]string `json:"tags,omitempty"`
+	Tags map[string]string `json:"tags,omitempty" hash:"ignore"`
 
 	// RootDiskSize specifies the size of the root disk in GB
 	// +kubebuilder:validation:Minimum:=1
@@ -71,15 +105,229 @@ type CloudStackNodeClassSpec struct {
 	// +optional
 	RootDiskSize *int64 `json:"rootDiskSize,omitempty"`
 
-	// DiskOffering specifies the disk offering for data disks
+	// RootDiskOffering selects the disk offering used for the root volume instead of the
+	// disk offering mapped in the service offering, e.g. to place it on SSD primary storage.
+	// +kubebuilder:validation:XValidation:message="expected at least one, got none, ['id', 'name', 'storageTags', 'provisioningType']",rule="has(self.id) || has(self.name) || has(self.storageTags) || has(self.provisioningType)"
+	// +optional
+	RootDiskOffering *RootDiskOfferingSelector `json:"rootDiskOffering,omitempty"`
+
+	// RootDiskController specifies the disk controller of the root volume
+	// +kubebuilder:validation:Enum:={ide,scsi,virtio,osdefault}
+	// +optional
+	RootDiskController *string `json:"rootDiskController,omitempty"`
+
+	// DiskOffering is the name or ID of the disk offering used to attach a data disk
+	// to the instances at deploy time, e.g. to hold /var/lib/containerd.
+	// Formatting and mounting the disk is left to the user data.
 	// +optional
 	DiskOffering *string `json:"diskOffering,omitempty"`
 
+	// DataDiskSize specifies the size of the data disk in GB.
+	// It is required when the disk offering is customized and must be omitted otherwise.
+	// +kubebuilder:validation:Minimum:=1
+	// +optional
+	DataDiskSize *int64 `json:"dataDiskSize,omitempty"`
+
+	// DataDiskMinIOPS specifies the minimum IOPS of the data disk.
+	// It is required when the disk offering has customized IOPS.
+	// +kubebuilder:validation:Minimum:=1
+	// +optional
+	DataDiskMinIOPS *int64 `json:"dataDiskMinIOPS,omitempty"`
+
+	// DataDiskMaxIOPS specifies the maximum IOPS of the data disk.
+	// It is required when the disk offering has customized IOPS.
+	// +kubebuilder:validation:Minimum:=1
+	// +optional
+	DataDiskMaxIOPS *int64 `json:"dataDiskMaxIOPS,omitempty"`
+
+	// BlockDeviceMappings is a list of additional data volumes that are created and
+	// attached to the instances after they are deployed.
+	// +kubebuilder:validation:XValidation:message="only one block device mapping can be designated for containerd",rule="self.filter(x, has(x.containerd) && x.containerd).size() <= 1"
+	// +kubebuilder:validation:XValidation:message="deviceID must be unique",rule="self.all(x, !has(x.deviceID) || self.filter(y, has(y.deviceID) && y.deviceID == x.deviceID).size() == 1)"
+	// +kubebuilder:validation:MaxItems:=10
+	// +optional
+	BlockDeviceMappings []BlockDeviceMapping `json:"blockDeviceMappings,omitempty"`
+
 	// SSHKeyPair is the name of the SSH keypair to use for the instances
 	// +optional
 	SSHKeyPair *string `json:"sshKeyPair,omitempty"`
 }
 
+// UserDataSource references the key of a Secret or ConfigMap holding the user data
+// +kubebuilder:validation:XValidation:message="expected exactly one of ['secretKeyRef', 'configMapKeyRef']",rule="has(self.secretKeyRef) != has(self.configMapKeyRef)"
+type UserDataSource struct {
+	// SecretKeyRef references a Secret key
+	// +optional
+	SecretKeyRef *KeyReference `json:"secretKeyRef,omitempty"`
+
+	// ConfigMapKeyRef references a ConfigMap key
+	// +optional
+	ConfigMapKeyRef *KeyReference `json:"configMapKeyRef,omitempty"`
+}
+
+// KeyReference references a key of a namespaced object
+type KeyReference struct {
+	// Namespace of the referenced object
+	// +kubebuilder:validation:MinLength:=1
+	// +required
+	Namespace string `json:"namespace"`
+
+	// Name of the referenced object
+	// +kubebuilder:validation:MinLength:=1
+	// +required
+	Name string `json:"name"`
+
+	// Key within the referenced object
+	// +kubebuilder:validation:MinLength:=1
+	// +required
+	Key string `json:"key"`
+}
+
+// BootstrapConfiguration configures how nodes join the cluster
+// +kubebuilder:validation:XValidation:message="token and tokenScope are mutually exclusive",rule="!(has(self.token) && has(self.tokenScope))"
+type BootstrapConfiguration struct {
+	// ServerURL is the URL nodes join. Defaults to the cluster endpoint the controller is configured with.
+	// RKE2 agents join through the supervisor port, e.g. https://server:9345.
+	// +kubebuilder:validation:Pattern:="^https://"
+	// +optional
+	ServerURL *string `json:"serverURL,omitempty"`
+
+	// Token is the token nodes use to join the cluster.
+	// Prefer TokenScope, which mints short-lived bootstrap tokens instead.
+	// +optional
+	Token *string `json:"token,omitempty"`
+
+	// TokenScope mints short-lived bootstrap tokens as kube-system/bootstrap-token-* Secrets.
+	// Launch mints a token for every node. NodePool shares a token between the nodes of a
+	// NodePool and rotates it once half of its TTL has passed.
+	// +kubebuilder:validation:Enum:={Launch,NodePool}
+	// +optional
+	TokenScope *string `json:"tokenScope,omitempty"`
+
+	// TokenTTL is the lifetime of the minted bootstrap tokens
+	// +kubebuilder:default:="1h"
+	// +kubebuilder:validation:XValidation:message="tokenTTL must be at least 10m",rule="duration(self) >= duration('10m')"
+	// +optional
+	TokenTTL *metav1.Duration `json:"tokenTTL,omitempty"`
+}
+
+// KubeletConfiguration defines args to be used when configuring kubelet on provisioned nodes.
+// They are a subset of the upstream types, recognizing not all options may be supported.
+// Wherever possible, the types and names should reflect the upstream kubelet types.
+// +kubebuilder:validation:XValidation:message="evictionSoft OwnerKey does not have a matching evictionSoftGracePeriod",rule="has(self.evictionSoft) ? self.evictionSoft.all(e, has(self.evictionSoftGracePeriod) && (e in self.evictionSoftGracePeriod)) : true"
+// +kubebuilder:validation:XValidation:message="evictionSoftGracePeriod OwnerKey does not have a matching evictionSoft",rule="has(self.evictionSoftGracePeriod) ? self.evictionSoftGracePeriod.all(e, has(self.evictionSoft) && (e in self.evictionSoft)) : true"
+type KubeletConfiguration struct {
+	// ClusterDNS is a list of IP addresses for the cluster DNS server.
+	// Note that not all providers may use all addresses.
+	// +optional
+	ClusterDNS []string `json:"clusterDNS,omitempty"`
+
+	// MaxPods is an override for the maximum number of pods that can run on a node.
+	// Defaults to 110.
+	// +kubebuilder:validation:Minimum:=0
+	// +optional
+	MaxPods *int32 `json:"maxPods,omitempty"`
+
+	// PodsPerCore is an override for the number of pods that can run on a node based on
+	// the number of CPU cores. The resulting max pods is the lower of MaxPods and PodsPerCore * cores.
+	// +kubebuilder:validation:Minimum:=0
+	// +optional
+	PodsPerCore *int32 `json:"podsPerCore,omitempty"`
+
+	// SystemReserved contains resources reserved for OS system daemons and kernel memory.
+	// +kubebuilder:validation:XValidation:message="valid keys for systemReserved are ['cpu','memory','ephemeral-storage','pid']",rule="self.all(x, x=='cpu' || x=='memory' || x=='ephemeral-storage' || x=='pid')"
+	// +kubebuilder:validation:XValidation:message="systemReserved value cannot be a negative resource quantity",rule="self.all(x, !self[x].startsWith('-'))"
+	// +optional
+	SystemReserved map[string]string `json:"systemReserved,omitempty"`
+
+	// KubeReserved contains resources reserved for Kubernetes system components.
+	// Defaults to 100m CPU and 256Mi memory.
+	// +kubebuilder:validation:XValidation:message="valid keys for kubeReserved are ['cpu','memory','ephemeral-storage','pid']",rule="self.all(x, x=='cpu' || x=='memory' || x=='ephemeral-storage' || x=='pid')"
+	// +kubebuilder:validation:XValidation:message="kubeReserved value cannot be a negative resource quantity",rule="self.all(x, !self[x].startsWith('-'))"
+	// +optional
+	KubeReserved map[string]string `json:"kubeReserved,omitempty"`
+
+	// EvictionHard is the map of signal names to quantities that define hard eviction thresholds
+	// +kubebuilder:validation:XValidation:message="valid keys for evictionHard are ['memory.available','nodefs.available','nodefs.inodesFree','imagefs.available','imagefs.inodesFree','pid.available']",rule="self.all(x, x in ['memory.available','nodefs.available','nodefs.inodesFree','imagefs.available','imagefs.inodesFree','pid.available'])"
+	// +optional
+	EvictionHard map[string]string `json:"evictionHard,omitempty"`
+
+	// EvictionSoft is the map of signal names to quantities that define soft eviction thresholds
+	// +kubebuilder:validation:XValidation:message="valid keys for evictionSoft are ['memory.available','nodefs.available','nodefs.inodesFree','imagefs.available','imagefs.inodesFree','pid.available']",rule="self.all(x, x in ['memory.available','nodefs.available','nodefs.inodesFree','imagefs.available','imagefs.inodesFree','pid.available'])"
+	// +optional
+	EvictionSoft map[string]string `json:"evictionSoft,omitempty"`
+
+	// EvictionSoftGracePeriod is the map of signal names to quantities that define grace periods for each eviction signal
+	// +kubebuilder:validation:XValidation:message="valid keys for evictionSoftGracePeriod are ['memory.available','nodefs.available','nodefs.inodesFree','imagefs.available','imagefs.inodesFree','pid.available']",rule="self.all(x, x in ['memory.available','nodefs.available','nodefs.inodesFree','imagefs.available','imagefs.inodesFree','pid.available'])"
+	// +optional
+	EvictionSoftGracePeriod map[string]metav1.Duration `json:"evictionSoftGracePeriod,omitempty"`
+}
+
+// RootDiskOfferingSelector defines selection logic for the root disk offering.
+// If multiple fields are used for selection, the requirements are ANDed.
+type RootDiskOfferingSelector struct {
+	// ID is the disk offering id in CloudStack
+	// +optional
+	ID string `json:"id,omitempty"`
+
+	// Name is the disk offering name in CloudStack
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// StorageTags selects disk offerings carrying all of the given storage tags
+	// +kubebuilder:validation:XValidation:message="empty storage tags aren't supported",rule="self.all(x, x != '')"
+	// +kubebuilder:validation:MaxItems:=10
+	// +optional
+	StorageTags []string `json:"storageTags,omitempty"`
+
+	// ProvisioningType selects disk offerings with the given provisioning type
+	// +kubebuilder:validation:Enum:={thin,sparse,fat}
+	// +optional
+	ProvisioningType string `json:"provisioningType,omitempty"`
+}
+
+// BlockDeviceMapping describes a data volume created from a disk offering and attached to an instance.
+// +kubebuilder:validation:XValidation:message="minIOPS must be less than or equal to maxIOPS",rule="!(has(self.minIOPS) && has(self.maxIOPS)) || self.minIOPS <= self.maxIOPS"
+type BlockDeviceMapping struct {
+	// DiskOffering is the name or ID of the disk offering used to create the volume
+	// +kubebuilder:validation:MinLength:=1
+	// +required
+	DiskOffering string `json:"diskOffering"`
+
+	// Size is the size of the volume in GB.
+	// It is required when the disk offering is customized and must be omitted otherwise.
+	// +kubebuilder:validation:Minimum:=1
+	// +optional
+	Size *int64 `json:"size,omitempty"`
+
+	// MinIOPS is the minimum IOPS of the volume, for disk offerings with customized IOPS
+	// +kubebuilder:validation:Minimum:=1
+	// +optional
+	MinIOPS *int64 `json:"minIOPS,omitempty"`
+
+	// MaxIOPS is the maximum IOPS of the volume, for disk offerings with customized IOPS
+	// +kubebuilder:validation:Minimum:=1
+	// +optional
+	MaxIOPS *int64 `json:"maxIOPS,omitempty"`
+
+	// DeviceID is the device ID used to attach the volume.
+	// When omitted, CloudStack assigns the next available device ID.
+	// +kubebuilder:validation:Minimum:=1
+	// +optional
+	DeviceID *int64 `json:"deviceID,omitempty"`
+
+	// DeleteOnTermination indicates whether the volume is deleted when the instance is terminated.
+	// Defaults to true.
+	// +optional
+	DeleteOnTermination *bool `json:"deleteOnTermination,omitempty"`
+
+	// Containerd designates this volume as the one backing /var/lib/containerd.
+	// Its size is advertised as the ephemeral-storage capacity of the node.
+	// Formatting and mounting the volume is left to the user data.
+	// +optional
+	Containerd bool `json:"containerd,omitempty"`
+}
+
 // NetworkSelectorTerm defines selection logic for a network used by Karpenter to launch nodes.
 // If multiple fields are used for selection, the requirements are ANDed.
 type NetworkSelectorTerm struct {
@@ -139,6 +387,46 @@ type TemplateSelectorTerm struct {
 	// OSType filters templates by operating system type
 	// +optional
 	OSType string `json:"osType,omitempty"`
+
+	// Hypervisor filters templates by hypervisor, e.g. KVM, VMware or XenServer
+	// +optional
+	Hypervisor string `json:"hypervisor,omitempty"`
+
+	// BootType filters templates by boot type
+	// +kubebuilder:validation:Enum:={BIOS,UEFI}
+	// +optional
+	BootType string `json:"bootType,omitempty"`
+
+	// PasswordEnabled filters templates by whether they support password reset
+	// +optional
+	PasswordEnabled *bool `json:"passwordEnabled,omitempty"`
+
+	// SSHKeyEnabled filters templates by whether they support SSH key injection
+	// +optional
+	SSHKeyEnabled *bool `json:"sshKeyEnabled,omitempty"`
+
+	// VersionTag is a template tag holding a semantic version, e.g. "1.31.2" or "v1.31.2".
+	// Templates matched by this term are ordered by that version, highest first, instead of
+	// by creation time. Templates whose tag is missing or isn't a version are ordered last.
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	VersionTag string `json:"versionTag,omitempty"`
+
+	// KubernetesVersion selects, among the templates matched by Tags and OSType, the templates built
+	// for the Kubernetes version of the API server. The version is rediscovered periodically, so that
+	// the selected templates follow control plane upgrades.
+	// +optional
+	KubernetesVersion *KubernetesVersionSelector `json:"kubernetesVersion,omitempty"`
+}
+
+// KubernetesVersionSelector selects templates built for the Kubernetes version of the API server
+type KubernetesVersionSelector struct {
+	// Tag is the template tag holding the Kubernetes version the template is built for, e.g. "1.31"
+	// or "v1.31.2". Templates are selected when their major and minor versions match the API server.
+	// +kubebuilder:default:="kubernetes-version"
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	Tag string `json:"tag,omitempty"`
 }
 
 // CloudStackNodeClassStatus contains the resolved state of the CloudStackNodeClass
@@ -151,10 +439,23 @@ type CloudStackNodeClassStatus struct {
 	// +optional
 	ServiceOfferings []ServiceOffering `json:"serviceOfferings,omitempty"`
 
-	// Templates contains the resolved templates
+	// Templates contains the templates matching the selector terms with their per-zone readiness,
+	// ordered by preference. The first ready template of a zone is the one new nodes launch with.
 	// +optional
 	Templates []Template `json:"templates,omitempty"`
 
+	// RootDiskOffering contains the resolved root disk offering
+	// +optional
+	RootDiskOffering *DiskOffering `json:"rootDiskOffering,omitempty"`
+
+	// DiskOffering contains the resolved data disk offering
+	// +optional
+	DiskOffering *DiskOffering `json:"diskOffering,omitempty"`
+
+	// UserDataHash is the hash of the user data referenced by UserDataFrom
+	// +optional
+	UserDataHash string `json:"userDataHash,omitempty"`
+
 	// Conditions contains signals for health and readiness
 	// +optional
 	Conditions []status.Condition `json:"conditions,omitempty"`
@@ -198,6 +499,39 @@ type Template struct {
 	OSType string `json:"osType,omitempty"`
 	// Zone is the zone where this template is available
 	Zone string `json:"zone"`
+	// Ready is true when the template is downloaded and can be deployed in the zone. Templates are
+	// copied to each zone separately and aren't deployable until the copy completes.
+	Ready bool `json:"ready"`
+	// Hypervisor is the hypervisor the template is built for
+	// +optional
+	Hypervisor string `json:"hypervisor,omitempty"`
+	// BootType is the template boot type, BIOS or UEFI
+	// +optional
+	BootType string `json:"bootType,omitempty"`
+	// Version is the template version read from the selector term VersionTag
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Created is the template creation time
+	// +optional
+	Created *metav1.Time `json:"created,omitempty"`
+}
+
+// DiskOffering describes a CloudStack disk offering
+type DiskOffering struct {
+	// ID is the disk offering ID
+	ID string `json:"id"`
+	// Name is the disk offering name
+	Name string `json:"name"`
+	// DiskSize is the disk size in GB, as configured on the offering or requested for customized offerings
+	DiskSize int64 `json:"diskSize,omitempty"`
+	// Customized is true when the disk size is set at deploy time
+	Customized bool `json:"customized,omitempty"`
+	// CustomizedIOPS is true when the disk IOPS are set at deploy time
+	CustomizedIOPS bool `json:"customizedIOPS,omitempty"`
+	// StorageTags are the storage tags of the disk offering
+	StorageTags string `json:"storageTags,omitempty"`
+	// ProvisioningType is the provisioning type of the disk offering (thin, sparse or fat)
+	ProvisioningType string `json:"provisioningType,omitempty"`
 }
 
 // CloudStackNodeClass is the Schema for the CloudStackNodeClass API
@@ -226,21 +560,72 @@ type CloudStackNodeClassList struct {
 }
 
 // We need to bump the CloudStackNodeClassHashVersion when we make an update to the CloudStackNodeClass CRD
-const CloudStackNodeClassHashVersion = "v1"
+const CloudStackNodeClassHashVersion = "v2"
+
+// Bootstrap modes
+const (
+	BootstrapModeCustom  = "Custom"
+	BootstrapModeKubeadm = "Kubeadm"
+	BootstrapModeK3s     = "K3s"
+	BootstrapModeRKE2    = "RKE2"
+)
 
-// Hash returns a hash of the CloudStackNodeClass spec
+// User data compressions
+const (
+	UserDataCompressionNone = "None"
+	UserDataCompressionGzip = "Gzip"
+)
+
+// Status condition types. Ready is true when all of them are true.
+const (
+	ConditionTypeZoneReady             = "ZoneReady"
+	ConditionTypeNetworksReady         = "NetworksReady"
+	ConditionTypeTemplatesReady        = "TemplatesReady"
+	ConditionTypeServiceOfferingsReady = "ServiceOfferingsReady"
+	ConditionTypeDiskOfferingsReady    = "DiskOfferingsReady"
+	ConditionTypeSSHKeyPairReady       = "SSHKeyPairReady"
+	ConditionTypeUserDataValid         = "UserDataValid"
+	ConditionTypeKubeletValid          = "KubeletValid"
+)
+
+// Template boot types
+const (
+	BootTypeBIOS = "BIOS"
+	BootTypeUEFI = "UEFI"
+)
+
+// Bootstrap token scopes
+const (
+	TokenScopeLaunch   = "Launch"
+	TokenScopeNodePool = "NodePool"
+)
+
+// Hash returns a hash of the CloudStackNodeClass spec fields that drift the nodes when changed. The
+// fields updated in place on the existing instances, tagged hash:"ignore", are left out.
 func (in *CloudStackNodeClass) Hash() string {
-	return fmt.Sprint(lo.Must(hashstructure.Hash(in.Spec, hashstructure.FormatV2, &hashstructure.HashOptions{
+	hash := lo.Must(hashstructure.Hash(in.Spec, hashstructure.FormatV2, &hashstructure.HashOptions{
 		SlicesAsSets:    true,
 		IgnoreZeroValue: true,
 		ZeroNil:         true,
-	})))
+	}))
+	// The referenced user data isn't part of the spec, so that its content is hashed separately
+	if in.Status.UserDataHash != "" {
+		hash = lo.Must(hashstructure.Hash([]any{hash, in.Status.UserDataHash}, hashstructure.FormatV2, nil))
+	}
+	return fmt.Sprint(hash)
 }
 
 // StatusConditions returns a ConditionSet for evaluating the status of CloudStackNodeClass
 func (in *CloudStackNodeClass) StatusConditions() status.ConditionSet {
 	conditionTypes := []string{
-		"Ready",
+		ConditionTypeZoneReady,
+		ConditionTypeNetworksReady,
+		ConditionTypeTemplatesReady,
+		ConditionTypeServiceOfferingsReady,
+		ConditionTypeDiskOfferingsReady,
+		ConditionTypeSSHKeyPairReady,
+		ConditionTypeUserDataValid,
+		ConditionTypeKubeletValid,
 	}
 	return status.NewReadyConditions(conditionTypes...).For(in)
 }
@@ -47,10 +47,23 @@ const (
 	NodeClassTagKey   = "karpenter.k8s.cloudstack/nodeclass"
 	ClusterNameTagKey = "kubernetes.io/cluster"
 	ManagedByTagKey   = "karpenter.sh/managed-by"
+	NodeNameTagKey    = "karpenter.k8s.cloudstack/node-name"
+
+	// DeleteOnTerminationTagKey marks data volumes that are deleted along with their instance
+	DeleteOnTerminationTagKey = "karpenter.k8s.cloudstack/delete-on-termination"
+
+	// TerminationFinalizer holds the deletion of a NodeClass until no NodeClaims or NodePools reference it
+	TerminationFinalizer = "karpenter.k8s.cloudstack/termination"
 
 	// Annotations
 	AnnotationNodeClassHash        = "karpenter.k8s.cloudstack/nodeclass-hash"
 	AnnotationNodeClassHashVersion = "karpenter.k8s.cloudstack/nodeclass-hash-version"
+	// AnnotationInstanceTags records the tags last set on the instance of a NodeClaim, so that the tags
+	// removed from the NodeClass are removed from the instance as well
+	AnnotationInstanceTags = "karpenter.k8s.cloudstack/instance-tags"
+	// AnnotationNodeClassTags recorded only the NodeClass tags set on the instance of a NodeClaim.
+	// Deprecated: use AnnotationInstanceTags, NodeClaims are migrated by the tagging controller.
+	AnnotationNodeClassTags = "karpenter.k8s.cloudstack/nodeclass-tags"
 )
 
 // Well-known label values
@@ -20,9 +20,85 @@ package v1
 
 import (
 	"github.com/awslabs/operatorpkg/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlockDeviceMapping) DeepCopyInto(out *BlockDeviceMapping) {
+	*out = *in
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		*out = new(int64)
+		**out = **in
+	}
+	if in.MinIOPS != nil {
+		in, out := &in.MinIOPS, &out.MinIOPS
+		*out = new(int64)
+		**out = **in
+	}
+	if in.MaxIOPS != nil {
+		in, out := &in.MaxIOPS, &out.MaxIOPS
+		*out = new(int64)
+		**out = **in
+	}
+	if in.DeviceID != nil {
+		in, out := &in.DeviceID, &out.DeviceID
+		*out = new(int64)
+		**out = **in
+	}
+	if in.DeleteOnTermination != nil {
+		in, out := &in.DeleteOnTermination, &out.DeleteOnTermination
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlockDeviceMapping.
+func (in *BlockDeviceMapping) DeepCopy() *BlockDeviceMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(BlockDeviceMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BootstrapConfiguration) DeepCopyInto(out *BootstrapConfiguration) {
+	*out = *in
+	if in.ServerURL != nil {
+		in, out := &in.ServerURL, &out.ServerURL
+		*out = new(string)
+		**out = **in
+	}
+	if in.Token != nil {
+		in, out := &in.Token, &out.Token
+		*out = new(string)
+		**out = **in
+	}
+	if in.TokenScope != nil {
+		in, out := &in.TokenScope, &out.TokenScope
+		*out = new(string)
+		**out = **in
+	}
+	if in.TokenTTL != nil {
+		in, out := &in.TokenTTL, &out.TokenTTL
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BootstrapConfiguration.
+func (in *BootstrapConfiguration) DeepCopy() *BootstrapConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(BootstrapConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CloudStackNodeClass) DeepCopyInto(out *CloudStackNodeClass) {
 	*out = *in
@@ -111,6 +187,31 @@ func (in *CloudStackNodeClassSpec) DeepCopyInto(out *CloudStackNodeClassSpec) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.UserDataFrom != nil {
+		in, out := &in.UserDataFrom, &out.UserDataFrom
+		*out = new(UserDataSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UserDataCompression != nil {
+		in, out := &in.UserDataCompression, &out.UserDataCompression
+		*out = new(string)
+		**out = **in
+	}
+	if in.BootstrapMode != nil {
+		in, out := &in.BootstrapMode, &out.BootstrapMode
+		*out = new(string)
+		**out = **in
+	}
+	if in.Bootstrap != nil {
+		in, out := &in.Bootstrap, &out.Bootstrap
+		*out = new(BootstrapConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Kubelet != nil {
+		in, out := &in.Kubelet, &out.Kubelet
+		*out = new(KubeletConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Tags != nil {
 		in, out := &in.Tags, &out.Tags
 		*out = make(map[string]string, len(*in))
@@ -123,11 +224,43 @@ func (in *CloudStackNodeClassSpec) DeepCopyInto(out *CloudStackNodeClassSpec) {
 		*out = new(int64)
 		**out = **in
 	}
+	if in.RootDiskOffering != nil {
+		in, out := &in.RootDiskOffering, &out.RootDiskOffering
+		*out = new(RootDiskOfferingSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RootDiskController != nil {
+		in, out := &in.RootDiskController, &out.RootDiskController
+		*out = new(string)
+		**out = **in
+	}
 	if in.DiskOffering != nil {
 		in, out := &in.DiskOffering, &out.DiskOffering
 		*out = new(string)
 		**out = **in
 	}
+	if in.DataDiskSize != nil {
+		in, out := &in.DataDiskSize, &out.DataDiskSize
+		*out = new(int64)
+		**out = **in
+	}
+	if in.DataDiskMinIOPS != nil {
+		in, out := &in.DataDiskMinIOPS, &out.DataDiskMinIOPS
+		*out = new(int64)
+		**out = **in
+	}
+	if in.DataDiskMaxIOPS != nil {
+		in, out := &in.DataDiskMaxIOPS, &out.DataDiskMaxIOPS
+		*out = new(int64)
+		**out = **in
+	}
+	if in.BlockDeviceMappings != nil {
+		in, out := &in.BlockDeviceMappings, &out.BlockDeviceMappings
+		*out = make([]BlockDeviceMapping, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.SSHKeyPair != nil {
 		in, out := &in.SSHKeyPair, &out.SSHKeyPair
 		*out = new(string)
@@ -161,7 +294,19 @@ func (in *CloudStackNodeClassStatus) DeepCopyInto(out *CloudStackNodeClassStatus
 	if in.Templates != nil {
 		in, out := &in.Templates, &out.Templates
 		*out = make([]Template, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RootDiskOffering != nil {
+		in, out := &in.RootDiskOffering, &out.RootDiskOffering
+		*out = new(DiskOffering)
+		**out = **in
+	}
+	if in.DiskOffering != nil {
+		in, out := &in.DiskOffering, &out.DiskOffering
+		*out = new(DiskOffering)
+		**out = **in
 	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
@@ -182,6 +327,116 @@ func (in *CloudStackNodeClassStatus) DeepCopy() *CloudStackNodeClassStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiskOffering) DeepCopyInto(out *DiskOffering) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiskOffering.
+func (in *DiskOffering) DeepCopy() *DiskOffering {
+	if in == nil {
+		return nil
+	}
+	out := new(DiskOffering)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyReference) DeepCopyInto(out *KeyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyReference.
+func (in *KeyReference) DeepCopy() *KeyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeletConfiguration) DeepCopyInto(out *KubeletConfiguration) {
+	*out = *in
+	if in.ClusterDNS != nil {
+		in, out := &in.ClusterDNS, &out.ClusterDNS
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxPods != nil {
+		in, out := &in.MaxPods, &out.MaxPods
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PodsPerCore != nil {
+		in, out := &in.PodsPerCore, &out.PodsPerCore
+		*out = new(int32)
+		**out = **in
+	}
+	if in.SystemReserved != nil {
+		in, out := &in.SystemReserved, &out.SystemReserved
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.KubeReserved != nil {
+		in, out := &in.KubeReserved, &out.KubeReserved
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.EvictionHard != nil {
+		in, out := &in.EvictionHard, &out.EvictionHard
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.EvictionSoft != nil {
+		in, out := &in.EvictionSoft, &out.EvictionSoft
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.EvictionSoftGracePeriod != nil {
+		in, out := &in.EvictionSoftGracePeriod, &out.EvictionSoftGracePeriod
+		*out = make(map[string]metav1.Duration, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeletConfiguration.
+func (in *KubeletConfiguration) DeepCopy() *KubeletConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeletConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubernetesVersionSelector) DeepCopyInto(out *KubernetesVersionSelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubernetesVersionSelector.
+func (in *KubernetesVersionSelector) DeepCopy() *KubernetesVersionSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(KubernetesVersionSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Network) DeepCopyInto(out *Network) {
 	*out = *in
@@ -219,6 +474,26 @@ func (in *NetworkSelectorTerm) DeepCopy() *NetworkSelectorTerm {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RootDiskOfferingSelector) DeepCopyInto(out *RootDiskOfferingSelector) {
+	*out = *in
+	if in.StorageTags != nil {
+		in, out := &in.StorageTags, &out.StorageTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RootDiskOfferingSelector.
+func (in *RootDiskOfferingSelector) DeepCopy() *RootDiskOfferingSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(RootDiskOfferingSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceOffering) DeepCopyInto(out *ServiceOffering) {
 	*out = *in
@@ -259,6 +534,10 @@ func (in *ServiceOfferingSelectorTerm) DeepCopy() *ServiceOfferingSelectorTerm {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Template) DeepCopyInto(out *Template) {
 	*out = *in
+	if in.Created != nil {
+		in, out := &in.Created, &out.Created
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Template.
@@ -281,6 +560,21 @@ func (in *TemplateSelectorTerm) DeepCopyInto(out *TemplateSelectorTerm) {
 			(*out)[key] = val
 		}
 	}
+	if in.PasswordEnabled != nil {
+		in, out := &in.PasswordEnabled, &out.PasswordEnabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.SSHKeyEnabled != nil {
+		in, out := &in.SSHKeyEnabled, &out.SSHKeyEnabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.KubernetesVersion != nil {
+		in, out := &in.KubernetesVersion, &out.KubernetesVersion
+		*out = new(KubernetesVersionSelector)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplateSelectorTerm.
@@ -292,3 +586,28 @@ func (in *TemplateSelectorTerm) DeepCopy() *TemplateSelectorTerm {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UserDataSource) DeepCopyInto(out *UserDataSource) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(KeyReference)
+		**out = **in
+	}
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(KeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UserDataSource.
+func (in *UserDataSource) DeepCopy() *UserDataSource {
+	if in == nil {
+		return nil
+	}
+	out := new(UserDataSource)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -39,6 +39,16 @@ import (
 
 var _ cloudprovider.CloudProvider = (*CloudProvider)(nil)
 
+// Drift reasons
+const (
+	NodeClassDrifted       cloudprovider.DriftReason = "NodeClassDrifted"
+	TemplateDrifted        cloudprovider.DriftReason = "TemplateDrifted"
+	ZoneDrifted            cloudprovider.DriftReason = "ZoneDrifted"
+	NetworkDrifted         cloudprovider.DriftReason = "NetworkDrifted"
+	ServiceOfferingDrifted cloudprovider.DriftReason = "ServiceOfferingDrifted"
+	SSHKeyPairDrifted      cloudprovider.DriftReason = "SSHKeyPairDrifted"
+)
+
 // CloudProvider implements the Karpenter CloudProvider interface for CloudStack
 type CloudProvider struct {
 	kubeClient           client.Client
@@ -71,6 +81,9 @@ func (c *CloudProvider) Create(ctx context.Context, nodeClaim *karpv1.NodeClaim)
 		}
 		return nil, fmt.Errorf("resolving nodeclass: %w", err)
 	}
+	if !nodeClass.DeletionTimestamp.IsZero() {
+		return nil, cloudprovider.NewInsufficientCapacityError(fmt.Errorf("nodeclass %s is being deleted", nodeClass.Name))
+	}
 
 	// Check if NodeClass is ready
 	readyCondition := nodeClass.GetCondition("Ready")
@@ -95,6 +108,7 @@ func (c *CloudProvider) Create(ctx context.Context, nodeClaim *karpv1.NodeClaim)
 	nc.Annotations = lo.Assign(nc.Annotations, map[string]string{
 		v1.AnnotationNodeClassHash:        nodeClass.Hash(),
 		v1.AnnotationNodeClassHashVersion: v1.CloudStackNodeClassHashVersion,
+		v1.AnnotationInstanceTags:         instance.FormatTags(inst.Tags),
 	})
 
 	log.FromContext(ctx).Info("Created node", "nodeClaim", nodeClaim.Name, "instanceID", inst.ID)
@@ -168,6 +182,10 @@ func (c *CloudProvider) GetInstanceTypes(ctx context.Context, nodePool *karpv1.N
 		}
 		return nil, fmt.Errorf("resolving nodeclass: %w", err)
 	}
+	// Nodes aren't launched from NodeClasses being deleted
+	if !nodeClass.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
 
 	instanceTypes, err := c.instanceTypeProvider.List(ctx, nodeClass)
 	if err != nil {
@@ -204,17 +222,79 @@ func (c *CloudProvider) IsDrifted(ctx context.Context, nodeClaim *karpv1.NodeCla
 		return "", fmt.Errorf("resolving nodeclass: %w", err)
 	}
 
-	// Check if hash has changed
+	// Check if the instance still matches the NodeClass, ahead of the hash to report the specific reason
+	if nodeClaim.Status.ProviderID != "" {
+		id, err := ParseProviderID(nodeClaim.Status.ProviderID)
+		if err != nil {
+			return "", fmt.Errorf("parsing provider ID: %w", err)
+		}
+		inst, err := c.instanceProvider.Get(ctx, id)
+		if err != nil {
+			return "", cloudprovider.IgnoreNodeClaimNotFoundError(fmt.Errorf("getting instance: %w", err))
+		}
+		if reason := instanceDrift(inst, nodeClass); reason != "" {
+			return reason, nil
+		}
+	}
+
+	// Check if hash has changed. Hashes of different versions can't be compared, and NodeClaims of a
+	// previous hash version are re-stamped by the NodeClass controller.
 	currentHash := nodeClaim.Annotations[v1.AnnotationNodeClassHash]
 	expectedHash := nodeClass.Hash()
 
-	if currentHash != expectedHash {
-		return "NodeClassDrifted", nil
+	if nodeClaim.Annotations[v1.AnnotationNodeClassHashVersion] == v1.CloudStackNodeClassHashVersion && currentHash != expectedHash {
+		return NodeClassDrifted, nil
+	}
+
+	// Check if a different template is resolved for new nodes
+	if isTemplateDrifted(nodeClaim, nodeClass) {
+		return TemplateDrifted, nil
 	}
 
 	return "", nil
 }
 
+// instanceDrift compares an instance against the NodeClass: its zone, whether the network it sits on and its
+// service offering are still resolved by the NodeClass, and its SSH key pair. Networks and service offerings
+// that haven't been resolved into the status yet aren't compared.
+func instanceDrift(inst *instance.Instance, nodeClass *v1.CloudStackNodeClass) cloudprovider.DriftReason {
+	if inst.Zone != nodeClass.Spec.Zone && inst.ZoneID != nodeClass.Spec.Zone {
+		return ZoneDrifted
+	}
+	if len(nodeClass.Status.Networks) > 0 && !lo.ContainsBy(nodeClass.Status.Networks, func(n v1.Network) bool {
+		return n.ID == inst.NetworkID
+	}) {
+		return NetworkDrifted
+	}
+	if len(nodeClass.Status.ServiceOfferings) > 0 && !lo.ContainsBy(nodeClass.Status.ServiceOfferings, func(o v1.ServiceOffering) bool {
+		return o.ID == inst.ServiceOfferingID
+	}) {
+		return ServiceOfferingDrifted
+	}
+	if sshKeyPair := lo.FromPtr(nodeClass.Spec.SSHKeyPair); sshKeyPair == "" && len(inst.SSHKeyPairs) > 0 ||
+		sshKeyPair != "" && !lo.Contains(inst.SSHKeyPairs, sshKeyPair) {
+		return SSHKeyPairDrifted
+	}
+	return ""
+}
+
+// isTemplateDrifted checks if the template of a NodeClaim differs from the template new nodes in its
+// zone launch with, which is the first ready template in the NodeClass status for that zone
+func isTemplateDrifted(nodeClaim *karpv1.NodeClaim, nodeClass *v1.CloudStackNodeClass) bool {
+	if nodeClaim.Status.ImageID == "" {
+		return false
+	}
+	zone := nodeClaim.Labels[corev1.LabelTopologyZone]
+	template, found := lo.Find(nodeClass.Status.Templates, func(t v1.Template) bool {
+		return t.Ready && (zone == "" || t.Zone == zone)
+	})
+	if !found {
+		// Templates haven't been resolved yet
+		return false
+	}
+	return template.ID != nodeClaim.Status.ImageID
+}
+
 // Name returns the cloud provider name
 func (c *CloudProvider) Name() string {
 	return "cloudstack"
@@ -246,35 +326,28 @@ func (c *CloudProvider) DisruptionReasons() []karpv1.DisruptionReason {
 	return nil
 }
 
-// resolveNodeClassFromNodeClaim resolves the NodeClass from a NodeClaim
+// resolveNodeClassFromNodeClaim resolves the NodeClass from a NodeClaim. NodeClasses being deleted are
+// returned as well, as their finalizer holds them until their NodeClaims are gone.
 func (c *CloudProvider) resolveNodeClassFromNodeClaim(ctx context.Context, nodeClaim *karpv1.NodeClaim) (*v1.CloudStackNodeClass, error) {
 	nodeClass := &v1.CloudStackNodeClass{}
 	if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: nodeClaim.Spec.NodeClassRef.Name}, nodeClass); err != nil {
 		return nil, err
 	}
 
-	if !nodeClass.DeletionTimestamp.IsZero() {
-		return nil, errors.NewNotFound(v1.SchemeGroupVersion.WithResource("cloudstacknodeclasses").GroupResource(), nodeClass.Name)
-	}
-
 	return nodeClass, nil
 }
 
-// resolveNodeClassFromNodePool resolves the NodeClass from a NodePool
+// resolveNodeClassFromNodePool resolves the NodeClass from a NodePool, including a NodeClass being deleted
 func (c *CloudProvider) resolveNodeClassFromNodePool(ctx context.Context, nodePool *karpv1.NodePool) (*v1.CloudStackNodeClass, error) {
 	nodeClass := &v1.CloudStackNodeClass{}
 	if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: nodePool.Spec.Template.Spec.NodeClassRef.Name}, nodeClass); err != nil {
 		return nil, err
 	}
 
-	if !nodeClass.DeletionTimestamp.IsZero() {
-		return nil, errors.NewNotFound(v1.SchemeGroupVersion.WithResource("cloudstacknodeclasses").GroupResource(), nodeClass.Name)
-	}
-
 	return nodeClass, nil
 }
 
-// resolveNodeClassFromInstance resolves the NodeClass from an instance
+// resolveNodeClassFromInstance resolves the NodeClass from an instance, including a NodeClass being deleted
 func (c *CloudProvider) resolveNodeClassFromInstance(ctx context.Context, inst *instance.Instance) (*v1.CloudStackNodeClass, error) {
 	nodeClassName, ok := inst.Tags[v1.NodeClassTagKey]
 	if !ok {
@@ -286,10 +359,6 @@ func (c *CloudProvider) resolveNodeClassFromInstance(ctx context.Context, inst *
 		return nil, err
 	}
 
-	if !nodeClass.DeletionTimestamp.IsZero() {
-		return nil, errors.NewNotFound(v1.SchemeGroupVersion.WithResource("cloudstacknodeclasses").GroupResource(), nodeClass.Name)
-	}
-
 	return nodeClass, nil
 }
 
@@ -317,6 +386,11 @@ func (c *CloudProvider) instanceToNodeClaim(inst *instance.Instance, nodeClass *
 		labels[karpv1.NodePoolLabelKey] = nodePoolName
 	}
 
+	// Add disk offering label if a data disk was attached
+	if inst.DiskOfferingID != "" {
+		labels[v1.LabelDiskOfferingID] = inst.DiskOfferingID
+	}
+
 	nodeClaim.Labels = labels
 	nodeClaim.Annotations = map[string]string{}
 	nodeClaim.CreationTimestamp = metav1.Time{Time: inst.CreatedTime}
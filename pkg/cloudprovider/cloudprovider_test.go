@@ -0,0 +1,207 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"testing"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instance"
+)
+
+func TestInstanceDrift(t *testing.T) {
+	nodeClass := func(sshKeyPair *string, networks []v1.Network, serviceOfferings []v1.ServiceOffering) *v1.CloudStackNodeClass {
+		return &v1.CloudStackNodeClass{
+			Spec: v1.CloudStackNodeClassSpec{Zone: "zone-a", SSHKeyPair: sshKeyPair},
+			Status: v1.CloudStackNodeClassStatus{
+				Networks:         networks,
+				ServiceOfferings: serviceOfferings,
+			},
+		}
+	}
+	networks := []v1.Network{{ID: "network-1"}, {ID: "network-2"}}
+	serviceOfferings := []v1.ServiceOffering{{ID: "offering-1"}, {ID: "offering-2"}}
+	inst := &instance.Instance{
+		Zone:              "zone-a",
+		ZoneID:            "zone-a-id",
+		NetworkID:         "network-2",
+		ServiceOfferingID: "offering-1",
+		SSHKeyPairs:       []string{"key"},
+	}
+	tests := []struct {
+		name      string
+		inst      *instance.Instance
+		nodeClass *v1.CloudStackNodeClass
+		want      cloudprovider.DriftReason
+	}{
+		{
+			name:      "matching instance",
+			inst:      inst,
+			nodeClass: nodeClass(lo.ToPtr("key"), networks, serviceOfferings),
+		},
+		{
+			name: "zone ID",
+			inst: inst,
+			nodeClass: func() *v1.CloudStackNodeClass {
+				nc := nodeClass(lo.ToPtr("key"), networks, serviceOfferings)
+				nc.Spec.Zone = "zone-a-id"
+				return nc
+			}(),
+		},
+		{
+			name: "zone",
+			inst: inst,
+			nodeClass: func() *v1.CloudStackNodeClass {
+				nc := nodeClass(lo.ToPtr("key"), networks, serviceOfferings)
+				nc.Spec.Zone = "zone-b"
+				return nc
+			}(),
+			want: ZoneDrifted,
+		},
+		{
+			name:      "network",
+			inst:      inst,
+			nodeClass: nodeClass(lo.ToPtr("key"), networks[:1], serviceOfferings),
+			want:      NetworkDrifted,
+		},
+		{
+			name:      "networks not resolved yet",
+			inst:      inst,
+			nodeClass: nodeClass(lo.ToPtr("key"), nil, serviceOfferings),
+		},
+		{
+			name:      "service offering",
+			inst:      inst,
+			nodeClass: nodeClass(lo.ToPtr("key"), networks, serviceOfferings[1:]),
+			want:      ServiceOfferingDrifted,
+		},
+		{
+			name:      "service offerings not resolved yet",
+			inst:      inst,
+			nodeClass: nodeClass(lo.ToPtr("key"), networks, nil),
+		},
+		{
+			name:      "SSH key pair",
+			inst:      inst,
+			nodeClass: nodeClass(lo.ToPtr("other-key"), networks, serviceOfferings),
+			want:      SSHKeyPairDrifted,
+		},
+		{
+			name:      "SSH key pair removed",
+			inst:      inst,
+			nodeClass: nodeClass(nil, networks, serviceOfferings),
+			want:      SSHKeyPairDrifted,
+		},
+		{
+			name:      "SSH key pair added",
+			inst:      &instance.Instance{Zone: "zone-a", NetworkID: "network-1", ServiceOfferingID: "offering-1"},
+			nodeClass: nodeClass(lo.ToPtr("key"), networks, serviceOfferings),
+			want:      SSHKeyPairDrifted,
+		},
+		{
+			name:      "no SSH key pair",
+			inst:      &instance.Instance{Zone: "zone-a", NetworkID: "network-1", ServiceOfferingID: "offering-1"},
+			nodeClass: nodeClass(nil, networks, serviceOfferings),
+		},
+		{
+			name:      "zone is reported first",
+			inst:      &instance.Instance{Zone: "zone-b", NetworkID: "network-3", ServiceOfferingID: "offering-3"},
+			nodeClass: nodeClass(nil, networks, serviceOfferings),
+			want:      ZoneDrifted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := instanceDrift(tt.inst, tt.nodeClass); got != tt.want {
+				t.Errorf("instanceDrift() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTemplateDrifted(t *testing.T) {
+	templates := []v1.Template{
+		{ID: "downloading", Zone: "zone-a"},
+		{ID: "template-a", Zone: "zone-a", Ready: true},
+		{ID: "old-template-a", Zone: "zone-a", Ready: true},
+		{ID: "template-b", Zone: "zone-b", Ready: true},
+	}
+	tests := []struct {
+		name      string
+		zone      string
+		imageID   string
+		templates []v1.Template
+		want      bool
+	}{
+		{
+			name:      "first ready template of the zone",
+			zone:      "zone-a",
+			imageID:   "template-a",
+			templates: templates,
+		},
+		{
+			name:      "older template of the zone",
+			zone:      "zone-a",
+			imageID:   "old-template-a",
+			templates: templates,
+			want:      true,
+		},
+		{
+			name:      "templates are compared per zone",
+			zone:      "zone-b",
+			imageID:   "template-b",
+			templates: templates,
+		},
+		{
+			name:      "first ready template without a zone label",
+			imageID:   "template-a",
+			templates: templates,
+		},
+		{
+			name:      "templates not resolved yet",
+			zone:      "zone-a",
+			imageID:   "old-template-a",
+			templates: nil,
+		},
+		{
+			name:      "no ready template in the zone",
+			zone:      "zone-a",
+			imageID:   "old-template-a",
+			templates: templates[:1],
+		},
+		{
+			name:      "instance not launched yet",
+			zone:      "zone-a",
+			templates: templates,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeClaim := &karpv1.NodeClaim{Status: karpv1.NodeClaimStatus{ImageID: tt.imageID}}
+			if tt.zone != "" {
+				nodeClaim.Labels = map[string]string{corev1.LabelTopologyZone: tt.zone}
+			}
+			nodeClass := &v1.CloudStackNodeClass{Status: v1.CloudStackNodeClassStatus{Templates: tt.templates}}
+			if got := isTemplateDrifted(nodeClaim, nodeClass); got != tt.want {
+				t.Errorf("isTemplateDrifted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
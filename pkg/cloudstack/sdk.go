@@ -32,6 +32,7 @@ type CloudStackAPI interface {
 	GetVirtualMachineID(name string, opts ...cloudstack.OptionFunc) (string, int, error)
 	ListVirtualMachines(p *cloudstack.ListVirtualMachinesParams) (*cloudstack.ListVirtualMachinesResponse, error)
 	DestroyVirtualMachine(p *cloudstack.DestroyVirtualMachineParams) (*cloudstack.DestroyVirtualMachineResponse, error)
+	UpdateVirtualMachine(p *cloudstack.UpdateVirtualMachineParams) (*cloudstack.UpdateVirtualMachineResponse, error)
 
 	// Service Offering operations
 	ListServiceOfferings(p *cloudstack.ListServiceOfferingsParams) (*cloudstack.ListServiceOfferingsResponse, error)
@@ -49,19 +50,45 @@ type CloudStackAPI interface {
 	ListZones(p *cloudstack.ListZonesParams) (*cloudstack.ListZonesResponse, error)
 	GetZoneID(name string, opts ...cloudstack.OptionFunc) (string, int, error)
 
+	// Hypervisor operations
+	ListHypervisors(p *cloudstack.ListHypervisorsParams) (*cloudstack.ListHypervisorsResponse, error)
+
 	// Disk Offering operations
 	ListDiskOfferings(p *cloudstack.ListDiskOfferingsParams) (*cloudstack.ListDiskOfferingsResponse, error)
 	GetDiskOfferingID(name string, opts ...cloudstack.OptionFunc) (string, int, error)
 
+	// Volume operations
+	CreateVolume(p *cloudstack.CreateVolumeParams) (*cloudstack.CreateVolumeResponse, error)
+	AttachVolume(p *cloudstack.AttachVolumeParams) (*cloudstack.AttachVolumeResponse, error)
+	DeleteVolume(p *cloudstack.DeleteVolumeParams) (*cloudstack.DeleteVolumeResponse, error)
+	ListVolumes(p *cloudstack.ListVolumesParams) (*cloudstack.ListVolumesResponse, error)
+
+	// SSH key pair operations
+	ListSSHKeyPairs(p *cloudstack.ListSSHKeyPairsParams) (*cloudstack.ListSSHKeyPairsResponse, error)
+
 	// Tag operations
 	CreateTags(p *cloudstack.CreateTagsParams) (*cloudstack.CreateTagsResponse, error)
 	DeleteTags(p *cloudstack.DeleteTagsParams) (*cloudstack.DeleteTagsResponse, error)
 	ListTags(p *cloudstack.ListTagsParams) (*cloudstack.ListTagsResponse, error)
+
+	// Event operations
+	ListEvents(p *cloudstack.ListEventsParams) (*cloudstack.ListEventsResponse, error)
 }
 
+const (
+	// MaxUserDataLengthGET is the maximum length of base64-encoded user data CloudStack accepts with HTTP GET
+	MaxUserDataLengthGET = 2048
+
+	// TimeLayout is the layout of CloudStack timestamps
+	TimeLayout = "2006-01-02T15:04:05-0700"
+)
+
 // Client wraps the official CloudStack Go SDK client
 type Client struct {
 	*cloudstack.CloudStackClient
+
+	// postClient sends the requests carrying large payloads with HTTP POST when the client is restricted to HTTP GET
+	postClient *cloudstack.CloudStackClient
 }
 
 // Config contains the configuration for the CloudStack client
@@ -71,6 +98,9 @@ type Config struct {
 	SecretKey string
 	VerifySSL bool
 	Timeout   time.Duration
+	// HTTPGETOnly restricts the client to HTTP GET, e.g. behind proxies rejecting POST.
+	// Deployments with large user data are still sent with HTTP POST.
+	HTTPGETOnly bool
 }
 
 // NewClient creates a new CloudStack client
@@ -103,13 +133,26 @@ func NewClient(ctx context.Context, cfg Config) (*Client, error) {
 		!cfg.VerifySSL,
 		cloudstack.WithHTTPClient(httpClient),
 	)
+	cs.HTTPGETOnly = cfg.HTTPGETOnly
+
+	postClient := cs
+	if cfg.HTTPGETOnly {
+		postClient = cloudstack.NewAsyncClient(
+			cfg.APIURL,
+			cfg.APIKey,
+			cfg.SecretKey,
+			!cfg.VerifySSL,
+			cloudstack.WithHTTPClient(httpClient),
+		)
+	}
 
 	log.FromContext(ctx).Info("CloudStack client initialized",
 		"apiURL", cfg.APIURL,
 		"verifySSL", cfg.VerifySSL,
-		"timeout", cfg.Timeout)
+		"timeout", cfg.Timeout,
+		"httpGETOnly", cfg.HTTPGETOnly)
 
-	return &Client{CloudStackClient: cs}, nil
+	return &Client{CloudStackClient: cs, postClient: postClient}, nil
 }
 
 // WaitForAsyncJob waits for an async job to complete and returns the result
@@ -146,8 +189,12 @@ func (c *Client) WaitForAsyncJob(ctx context.Context, jobID string, timeout time
 	}
 }
 
-// DeployVirtualMachine deploys a virtual machine
+// DeployVirtualMachine deploys a virtual machine. The deployment is sent with HTTP POST when the
+// user data is too large for HTTP GET, even if the client is restricted to HTTP GET.
 func (c *Client) DeployVirtualMachine(p *cloudstack.DeployVirtualMachineParams) (*cloudstack.DeployVirtualMachineResponse, error) {
+	if userData, ok := p.GetUserdata(); ok && len(userData) > MaxUserDataLengthGET {
+		return c.postClient.VirtualMachine.DeployVirtualMachine(p)
+	}
 	return c.VirtualMachine.DeployVirtualMachine(p)
 }
 
@@ -166,6 +213,11 @@ func (c *Client) DestroyVirtualMachine(p *cloudstack.DestroyVirtualMachineParams
 	return c.VirtualMachine.DestroyVirtualMachine(p)
 }
 
+// UpdateVirtualMachine updates a virtual machine
+func (c *Client) UpdateVirtualMachine(p *cloudstack.UpdateVirtualMachineParams) (*cloudstack.UpdateVirtualMachineResponse, error) {
+	return c.VirtualMachine.UpdateVirtualMachine(p)
+}
+
 // ListServiceOfferings lists service offerings
 func (c *Client) ListServiceOfferings(p *cloudstack.ListServiceOfferingsParams) (*cloudstack.ListServiceOfferingsResponse, error) {
 	return c.ServiceOffering.ListServiceOfferings(p)
@@ -206,6 +258,11 @@ func (c *Client) GetZoneID(name string, opts ...cloudstack.OptionFunc) (string,
 	return c.Zone.GetZoneID(name, opts...)
 }
 
+// ListHypervisors lists the hypervisors of the clusters
+func (c *Client) ListHypervisors(p *cloudstack.ListHypervisorsParams) (*cloudstack.ListHypervisorsResponse, error) {
+	return c.Hypervisor.ListHypervisors(p)
+}
+
 // ListDiskOfferings lists disk offerings
 func (c *Client) ListDiskOfferings(p *cloudstack.ListDiskOfferingsParams) (*cloudstack.ListDiskOfferingsResponse, error) {
 	return c.DiskOffering.ListDiskOfferings(p)
@@ -216,6 +273,31 @@ func (c *Client) GetDiskOfferingID(name string, opts ...cloudstack.OptionFunc) (
 	return c.DiskOffering.GetDiskOfferingID(name, opts...)
 }
 
+// CreateVolume creates a volume
+func (c *Client) CreateVolume(p *cloudstack.CreateVolumeParams) (*cloudstack.CreateVolumeResponse, error) {
+	return c.Volume.CreateVolume(p)
+}
+
+// AttachVolume attaches a volume to a virtual machine
+func (c *Client) AttachVolume(p *cloudstack.AttachVolumeParams) (*cloudstack.AttachVolumeResponse, error) {
+	return c.Volume.AttachVolume(p)
+}
+
+// DeleteVolume deletes a volume
+func (c *Client) DeleteVolume(p *cloudstack.DeleteVolumeParams) (*cloudstack.DeleteVolumeResponse, error) {
+	return c.Volume.DeleteVolume(p)
+}
+
+// ListVolumes lists volumes
+func (c *Client) ListVolumes(p *cloudstack.ListVolumesParams) (*cloudstack.ListVolumesResponse, error) {
+	return c.Volume.ListVolumes(p)
+}
+
+// ListSSHKeyPairs lists SSH key pairs
+func (c *Client) ListSSHKeyPairs(p *cloudstack.ListSSHKeyPairsParams) (*cloudstack.ListSSHKeyPairsResponse, error) {
+	return c.SSH.ListSSHKeyPairs(p)
+}
+
 // CreateTags creates tags
 func (c *Client) CreateTags(p *cloudstack.CreateTagsParams) (*cloudstack.CreateTagsResponse, error) {
 	return c.Resourcetags.CreateTags(p)
@@ -231,5 +313,10 @@ func (c *Client) ListTags(p *cloudstack.ListTagsParams) (*cloudstack.ListTagsRes
 	return c.Resourcetags.ListTags(p)
 }
 
+// ListEvents lists events
+func (c *Client) ListEvents(p *cloudstack.ListEventsParams) (*cloudstack.ListEventsResponse, error) {
+	return c.Event.ListEvents(p)
+}
+
 // Ensure Client implements CloudStackAPI
 var _ CloudStackAPI = (*Client)(nil)
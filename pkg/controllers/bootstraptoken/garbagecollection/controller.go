@@ -0,0 +1,60 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/operatorpkg/reconciler"
+	"github.com/awslabs/operatorpkg/singleton"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/bootstraptoken"
+)
+
+const (
+	controllerName = "bootstraptoken.garbagecollection"
+)
+
+// Controller deletes the expired bootstrap tokens minted by Karpenter
+type Controller struct {
+	bootstrapTokenProvider bootstraptoken.Provider
+}
+
+// NewController creates a new bootstrap token garbage collection controller
+func NewController(bootstrapTokenProvider bootstraptoken.Provider) *Controller {
+	return &Controller{
+		bootstrapTokenProvider: bootstrapTokenProvider,
+	}
+}
+
+// Reconcile deletes the expired bootstrap tokens
+func (c *Controller) Reconcile(ctx context.Context) (reconciler.Result, error) {
+	if err := c.bootstrapTokenProvider.DeleteExpired(ctx); err != nil {
+		return reconciler.Result{}, fmt.Errorf("deleting expired bootstrap tokens: %w", err)
+	}
+	return reconciler.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// Register registers the controller with the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.NewControllerManagedBy(m).
+		Named(controllerName).
+		WatchesRawSource(singleton.Source()).
+		Complete(singleton.AsReconciler(c))
+}
@@ -0,0 +1,263 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudstackevents
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/awslabs/operatorpkg/reconciler"
+	"github.com/awslabs/operatorpkg/singleton"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	csapi "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudstack"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instancetype"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/network"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
+)
+
+const (
+	controllerName = "cloudstackevents"
+
+	pollInterval = time.Minute
+
+	// clockSkew is how far the CloudStack clock may lag behind the controller's. Events are looked
+	// up this far before the previous poll, and the ones already handled are skipped.
+	clockSkew = 5 * time.Minute
+
+	eventStateCompleted = "Completed"
+
+	// startDateLayout is the layout of the listEvents start date, in the CloudStack server time zone
+	startDateLayout = "2006-01-02 15:04:05"
+)
+
+// cacheInvalidator is a provider caching CloudStack resources
+type cacheInvalidator interface {
+	InvalidateCache()
+}
+
+// Controller polls the CloudStack events for changes to the resources NodeClasses resolve, such as a
+// template being registered or a network being tagged. The caches of the changed resources are dropped
+// and the NodeClasses reconciled right away, instead of waiting for the caches to expire. Tag events
+// are left to the tagging controller, which owns the tags Karpenter sets.
+type Controller struct {
+	kubeClient      client.Client
+	csClient        csapi.CloudStackAPI
+	nodeClassEvents chan<- event.GenericEvent
+	invalidators    map[string][]cacheInvalidator
+
+	lastPoll time.Time
+	handled  map[string]time.Time
+	location *time.Location
+}
+
+// NewController creates a new CloudStack events controller. The NodeClasses to reconcile are sent
+// to nodeClassEvents.
+func NewController(
+	kubeClient client.Client,
+	csClient csapi.CloudStackAPI,
+	zoneProvider zone.Provider,
+	networkProvider network.Provider,
+	templateProvider template.Provider,
+	instanceTypeProvider instancetype.Provider,
+	nodeClassEvents chan<- event.GenericEvent,
+) *Controller {
+	return &Controller{
+		kubeClient:      kubeClient,
+		csClient:        csClient,
+		nodeClassEvents: nodeClassEvents,
+		// Templates and service offerings are listed per zone, so zone changes invalidate them as well
+		invalidators: map[string][]cacheInvalidator{
+			"TEMPLATE.CREATE":           {templateProvider, instanceTypeProvider},
+			"TEMPLATE.DELETE":           {templateProvider, instanceTypeProvider},
+			"TEMPLATE.UPDATE":           {templateProvider, instanceTypeProvider},
+			"TEMPLATE.COPY":             {templateProvider, instanceTypeProvider},
+			"TEMPLATE.DOWNLOAD.SUCCESS": {templateProvider, instanceTypeProvider},
+			"NETWORK.CREATE":            {networkProvider},
+			"NETWORK.DELETE":            {networkProvider},
+			"NETWORK.UPDATE":            {networkProvider},
+			"SERVICE.OFFERING.CREATE":   {instanceTypeProvider},
+			"SERVICE.OFFERING.EDIT":     {instanceTypeProvider},
+			"SERVICE.OFFERING.DELETE":   {instanceTypeProvider},
+			"ZONE.CREATE":               {zoneProvider, templateProvider, instanceTypeProvider},
+			"ZONE.EDIT":                 {zoneProvider, templateProvider, instanceTypeProvider},
+			"ZONE.DELETE":               {zoneProvider, templateProvider, instanceTypeProvider},
+		},
+		handled: map[string]time.Time{},
+	}
+}
+
+// Reconcile invalidates the caches of the resources changed since the previous poll and reconciles
+// the NodeClasses
+func (c *Controller) Reconcile(ctx context.Context) (reconciler.Result, error) {
+	now := time.Now()
+
+	// Changes made before the controller started are already reflected in the caches, which are
+	// populated from then on
+	if c.lastPoll.IsZero() {
+		c.lastPoll = now
+		return reconciler.Result{RequeueAfter: pollInterval}, nil
+	}
+
+	// Dates are listed in the CloudStack server time zone, which is looked up until an event reveals it
+	if c.location == nil {
+		location, err := c.serverLocation()
+		if err != nil {
+			return reconciler.Result{}, err
+		}
+		c.location = location
+	}
+
+	since := c.lastPoll.Add(-clockSkew)
+	created := map[string]time.Time{}
+	invalidators := map[cacheInvalidator]struct{}{}
+	for eventType, eventInvalidators := range c.invalidators {
+		events, err := c.listEvents(eventType, since)
+		if err != nil {
+			return reconciler.Result{}, err
+		}
+		for id, e := range events {
+			created[id] = e.created
+			log.FromContext(ctx).V(1).Info("Discovered CloudStack event", "type", eventType, "id", id, "resource", e.Resourcename)
+			for _, i := range eventInvalidators {
+				invalidators[i] = struct{}{}
+			}
+		}
+	}
+
+	if len(invalidators) > 0 {
+		for i := range invalidators {
+			i.InvalidateCache()
+		}
+		if err := c.reconcileNodeClasses(ctx); err != nil {
+			return reconciler.Result{}, err
+		}
+	}
+
+	// Remember the handled events, and forget the ones that can no longer be listed again
+	for id, t := range created {
+		c.handled[id] = t
+	}
+	for id, t := range c.handled {
+		if t.Before(since) {
+			delete(c.handled, id)
+		}
+	}
+	c.lastPoll = now
+
+	return reconciler.Result{RequeueAfter: pollInterval}, nil
+}
+
+// csEvent is a CloudStack event with its parsed creation time
+type csEvent struct {
+	*cloudstack.Event
+	created time.Time
+}
+
+// listEvents returns the completed events of a type created since the given time that weren't
+// handled yet, by ID
+func (c *Controller) listEvents(eventType string, since time.Time) (map[string]csEvent, error) {
+	params := c.csClient.(*csapi.Client).Event.NewListEventsParams()
+	params.SetType(eventType)
+	params.SetListall(true)
+	// The start date is in the CloudStack server time zone, UTC until it's known
+	location := time.UTC
+	if c.location != nil {
+		location = c.location
+	}
+	params.SetStartdate(since.In(location).Format(startDateLayout))
+	params.SetPagesize(500)
+
+	events := map[string]csEvent{}
+	for page := 1; ; page++ {
+		params.SetPage(page)
+		resp, err := c.csClient.ListEvents(params)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s events: %w", eventType, err)
+		}
+		for _, e := range resp.Events {
+			if e.State != eventStateCompleted {
+				continue
+			}
+			if _, ok := c.handled[e.Id]; ok {
+				continue
+			}
+			created, err := time.Parse(csapi.TimeLayout, e.Created)
+			if err != nil || created.Before(since) {
+				continue
+			}
+			events[e.Id] = csEvent{Event: e, created: created}
+		}
+		if len(resp.Events) == 0 || page*500 >= resp.Count {
+			break
+		}
+	}
+	return events, nil
+}
+
+// serverLocation returns the time zone of the CloudStack server, from the creation time of an event.
+// nil is returned when there are no events yet.
+func (c *Controller) serverLocation() (*time.Location, error) {
+	params := c.csClient.(*csapi.Client).Event.NewListEventsParams()
+	params.SetListall(true)
+	params.SetPage(1)
+	params.SetPagesize(1)
+
+	resp, err := c.csClient.ListEvents(params)
+	if err != nil {
+		return nil, fmt.Errorf("listing events: %w", err)
+	}
+	if len(resp.Events) == 0 {
+		return nil, nil
+	}
+	created, err := time.Parse(csapi.TimeLayout, resp.Events[0].Created)
+	if err != nil {
+		return nil, fmt.Errorf("parsing event creation time: %w", err)
+	}
+	return created.Location(), nil
+}
+
+// reconcileNodeClasses triggers the reconciliation of every NodeClass, re-resolving their resources
+func (c *Controller) reconcileNodeClasses(ctx context.Context) error {
+	nodeClasses := &v1.CloudStackNodeClassList{}
+	if err := c.kubeClient.List(ctx, nodeClasses); err != nil {
+		return fmt.Errorf("listing nodeclasses: %w", err)
+	}
+	for i := range nodeClasses.Items {
+		select {
+		case c.nodeClassEvents <- event.GenericEvent{Object: &nodeClasses.Items[i]}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	log.FromContext(ctx).Info("Reconciling nodeclasses after CloudStack resource changes", "nodeclasses", len(nodeClasses.Items))
+	return nil
+}
+
+// Register registers the controller with the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.NewControllerManagedBy(m).
+		Named(controllerName).
+		WatchesRawSource(singleton.Source()).
+		Complete(singleton.AsReconciler(c))
+}
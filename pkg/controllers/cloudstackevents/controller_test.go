@@ -0,0 +1,263 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudstackevents
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/apis"
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	csapi "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudstack"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instancetype"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/network"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
+)
+
+// serverZone is the time zone of the fake CloudStack server
+var serverZone = time.FixedZone("", 2*60*60)
+
+// fakeEventsServer is a CloudStack API server listing the events of each type, recording the
+// listEvents requests it receives
+type fakeEventsServer struct {
+	mu       sync.Mutex
+	events   map[string][]*cloudstack.Event
+	requests []url.Values
+}
+
+func (s *fakeEventsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.requests = append(s.requests, r.Form)
+
+	var events []*cloudstack.Event
+	if eventType := r.Form.Get("type"); eventType != "" {
+		events = s.events[eventType]
+	} else {
+		for _, eventType := range slices.Sorted(maps.Keys(s.events)) {
+			events = append(events, s.events[eventType]...)
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"listeventsresponse": map[string]any{"count": len(events), "event": events},
+	})
+}
+
+// typesListed returns the event types of the listEvents requests received
+func (s *fakeEventsServer) typesListed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var types []string
+	for _, r := range s.requests {
+		if t := r.Get("type"); t != "" {
+			types = append(types, t)
+		}
+	}
+	slices.Sort(types)
+	return types
+}
+
+// invalidations counts the cache invalidations of each provider
+type invalidations map[string]int
+
+type fakeZoneProvider struct {
+	zone.Provider
+	invalidations
+}
+
+func (f *fakeZoneProvider) InvalidateCache() { f.invalidations["zone"]++ }
+
+type fakeNetworkProvider struct {
+	network.Provider
+	invalidations
+}
+
+func (f *fakeNetworkProvider) InvalidateCache() { f.invalidations["network"]++ }
+
+type fakeTemplateProvider struct {
+	template.Provider
+	invalidations
+}
+
+func (f *fakeTemplateProvider) InvalidateCache() { f.invalidations["template"]++ }
+
+type fakeInstanceTypeProvider struct {
+	instancetype.Provider
+	invalidations
+}
+
+func (f *fakeInstanceTypeProvider) InvalidateCache() { f.invalidations["instancetype"]++ }
+
+// csEventAt returns a completed CloudStack event created at the given time
+func csEventAt(id, eventType string, created time.Time) *cloudstack.Event {
+	return &cloudstack.Event{Id: id, Type: eventType, State: eventStateCompleted, Created: created.In(serverZone).Format(csapi.TimeLayout)}
+}
+
+func newTestController(t *testing.T, server *fakeEventsServer) (*Controller, invalidations, chan event.GenericEvent) {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	csClient, err := csapi.NewClient(context.Background(), csapi.Config{APIURL: httpServer.URL, APIKey: "key", SecretKey: "secret"})
+	if err != nil {
+		t.Fatalf("creating CloudStack client: %v", err)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("adding APIs to scheme: %v", err)
+	}
+	kubeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1.CloudStackNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}}).Build()
+
+	counts := invalidations{}
+	nodeClassEvents := make(chan event.GenericEvent, 10)
+	c := NewController(kubeClient, csClient,
+		&fakeZoneProvider{invalidations: counts},
+		&fakeNetworkProvider{invalidations: counts},
+		&fakeTemplateProvider{invalidations: counts},
+		&fakeInstanceTypeProvider{invalidations: counts},
+		nodeClassEvents,
+	)
+	return c, counts, nodeClassEvents
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	server := &fakeEventsServer{events: map[string][]*cloudstack.Event{}}
+	c, counts, nodeClassEvents := newTestController(t, server)
+
+	// The first poll only starts the cursor
+	if _, err := c.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(server.requests) != 0 {
+		t.Errorf("first poll sent %d requests, want none", len(server.requests))
+	}
+	firstPoll := c.lastPoll
+
+	server.events["TEMPLATE.CREATE"] = []*cloudstack.Event{csEventAt("event-1", "TEMPLATE.CREATE", time.Now())}
+	server.events["NETWORK.UPDATE"] = []*cloudstack.Event{csEventAt("event-2", "NETWORK.UPDATE", firstPoll.Add(-time.Hour))}
+	if _, err := c.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if want := (invalidations{"template": 1, "instancetype": 1}); !maps.Equal(counts, want) {
+		t.Errorf("invalidations = %v, want %v", counts, want)
+	}
+	if len(nodeClassEvents) != 1 {
+		t.Errorf("reconciled %d nodeclasses, want 1", len(nodeClassEvents))
+	}
+	if c.location.String() != serverZone.String() {
+		t.Errorf("server location = %v, want %v", c.location, serverZone)
+	}
+	// The events are listed from the previous poll, less the clock skew, in the server time zone
+	for _, r := range server.requests {
+		if r.Get("type") == "" {
+			continue
+		}
+		if want := firstPoll.Add(-clockSkew).In(serverZone).Format(startDateLayout); r.Get("startdate") != want {
+			t.Errorf("listEvents startdate = %q, want %q", r.Get("startdate"), want)
+		}
+	}
+	// Tag events are left to the tagging controller
+	if types := server.typesListed(); slices.Contains(types, "CREATE_TAGS") || slices.Contains(types, "DELETE_TAGS") {
+		t.Errorf("listed event types %v, want no tag events", types)
+	}
+	secondPoll := c.lastPoll
+	if !secondPoll.After(firstPoll) {
+		t.Errorf("cursor = %v after the second poll, want after %v", secondPoll, firstPoll)
+	}
+
+	// Events already handled aren't handled again when listed within the clock skew
+	<-nodeClassEvents
+	server.requests = nil
+	if _, err := c.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if want := (invalidations{"template": 1, "instancetype": 1}); !maps.Equal(counts, want) {
+		t.Errorf("invalidations = %v after the event was handled, want %v", counts, want)
+	}
+	if len(nodeClassEvents) != 0 {
+		t.Errorf("reconciled %d nodeclasses after the event was handled, want 0", len(nodeClassEvents))
+	}
+	for _, r := range server.requests {
+		if want := secondPoll.Add(-clockSkew).In(serverZone).Format(startDateLayout); r.Get("startdate") != want {
+			t.Errorf("listEvents startdate = %q, want %q", r.Get("startdate"), want)
+		}
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	since := time.Now().Add(-time.Minute).Truncate(time.Second)
+	pending := csEventAt("event-3", "TEMPLATE.CREATE", time.Now())
+	pending.State = "Scheduled"
+	tests := []struct {
+		name    string
+		events  []*cloudstack.Event
+		handled map[string]time.Time
+		want    []string
+	}{
+		{
+			name:   "completed events created since the previous poll",
+			events: []*cloudstack.Event{csEventAt("event-1", "TEMPLATE.CREATE", time.Now()), csEventAt("event-2", "TEMPLATE.CREATE", since)},
+			want:   []string{"event-1", "event-2"},
+		},
+		{
+			name:   "events created before",
+			events: []*cloudstack.Event{csEventAt("event-1", "TEMPLATE.CREATE", since.Add(-time.Second))},
+		},
+		{
+			name:   "events not completed",
+			events: []*cloudstack.Event{pending},
+		},
+		{
+			name:    "events already handled",
+			events:  []*cloudstack.Event{csEventAt("event-1", "TEMPLATE.CREATE", time.Now()), csEventAt("event-2", "TEMPLATE.CREATE", time.Now())},
+			handled: map[string]time.Time{"event-1": time.Now()},
+			want:    []string{"event-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, _ := newTestController(t, &fakeEventsServer{events: map[string][]*cloudstack.Event{"TEMPLATE.CREATE": tt.events}})
+			if tt.handled != nil {
+				c.handled = tt.handled
+			}
+			events, err := c.listEvents("TEMPLATE.CREATE", since)
+			if err != nil {
+				t.Fatalf("listEvents() error = %v", err)
+			}
+			if got := slices.Sorted(maps.Keys(events)); !slices.Equal(got, tt.want) {
+				t.Errorf("listEvents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
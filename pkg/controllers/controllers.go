@@ -19,12 +19,25 @@ import (
 
 	"github.com/awslabs/operatorpkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/karpenter/pkg/events"
 
+	csapi "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudstack"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/controllers/bootstraptoken/garbagecollection"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/controllers/cloudstackevents"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/controllers/nodeclaim/tagging"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/controllers/nodeclass"
+	versioncontroller "github.com/mperea/karpenter-provider-cloudstack/pkg/controllers/version"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/bootstraptoken"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instance"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instancetype"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/network"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/sshkeypair"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/userdata"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/version"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
 )
 
@@ -34,10 +47,21 @@ func NewControllers(
 	mgr manager.Manager,
 	kubeClient client.Client,
 	recorder events.Recorder,
+	csClient csapi.CloudStackAPI,
 	zoneProvider zone.Provider,
 	networkProvider network.Provider,
 	templateProvider template.Provider,
+	instanceTypeProvider instancetype.Provider,
+	instanceProvider instance.Provider,
+	diskOfferingProvider diskoffering.Provider,
+	sshKeyPairProvider sshkeypair.Provider,
+	bootstrapTokenProvider bootstraptoken.Provider,
+	userDataProvider userdata.Provider,
+	versionProvider version.Provider,
 ) []controller.Controller {
+	// NodeClasses to reconcile, sent by the CloudStack events controller to the NodeClass controller
+	nodeClassEvents := make(chan event.GenericEvent)
+
 	return []controller.Controller{
 		nodeclass.NewController(
 			kubeClient,
@@ -45,6 +69,24 @@ func NewControllers(
 			zoneProvider,
 			networkProvider,
 			templateProvider,
+			instanceTypeProvider,
+			diskOfferingProvider,
+			sshKeyPairProvider,
+			userDataProvider,
+			bootstrapTokenProvider,
+			nodeClassEvents,
+		),
+		tagging.NewController(kubeClient, instanceProvider),
+		garbagecollection.NewController(bootstrapTokenProvider),
+		versioncontroller.NewController(versionProvider),
+		cloudstackevents.NewController(
+			kubeClient,
+			csClient,
+			zoneProvider,
+			networkProvider,
+			templateProvider,
+			instanceTypeProvider,
+			nodeClassEvents,
 		),
 	}
 }
@@ -0,0 +1,226 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tagging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/operatorpkg/reasonable"
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+	nodeclaimutils "sigs.k8s.io/karpenter/pkg/utils/nodeclaim"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	csprovider "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudprovider"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instance"
+)
+
+const (
+	controllerName = "nodeclaim.tagging"
+
+	// repairInterval is how often the tags of each instance are checked
+	repairInterval = 30 * time.Minute
+)
+
+// Controller keeps the tags of the instances of NodeClaims current: the Karpenter tags identifying the
+// cluster, NodePool, NodeClaim and NodeClass, and the NodeClass tags. Tags aren't part of the NodeClass
+// hash, so changing them updates the instances in place instead of drifting the nodes. Instances are
+// checked periodically as well, repairing the tags that failed to be created at launch or were removed.
+// Once the node registers, its name and the node labels selected by NODE_LABEL_TAGS are tagged too, so
+// that CloudStack operators can map VMs to Kubernetes nodes.
+type Controller struct {
+	kubeClient       client.Client
+	instanceProvider instance.Provider
+}
+
+// NewController creates a new NodeClaim tagging controller
+func NewController(kubeClient client.Client, instanceProvider instance.Provider) *Controller {
+	return &Controller{
+		kubeClient:       kubeClient,
+		instanceProvider: instanceProvider,
+	}
+}
+
+// Reconcile sets the current tags on the instance of a NodeClaim, and removes the tags previously set
+// that no longer apply
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := log.FromContext(ctx).WithValues("nodeclaim", req.Name)
+	ctx = log.IntoContext(ctx, logger)
+
+	nodeClaim := &karpv1.NodeClaim{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, nodeClaim); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if nodeClaim.Status.ProviderID == "" || !nodeClaim.DeletionTimestamp.IsZero() || !isCloudStackNodeClassRef(nodeClaim.Spec.NodeClassRef) {
+		return reconcile.Result{}, nil
+	}
+	nodeClass := &v1.CloudStackNodeClass{}
+	if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: nodeClaim.Spec.NodeClassRef.Name}, nodeClass); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	id, err := csprovider.ParseProviderID(nodeClaim.Status.ProviderID)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("parsing provider ID: %w", err)
+	}
+	node, err := nodeclaimutils.NodeForNodeClaim(ctx, c.kubeClient, nodeClaim)
+	if err != nil && !nodeclaimutils.IsNodeNotFoundError(err) {
+		return reconcile.Result{}, fmt.Errorf("getting node: %w", err)
+	}
+	// The Karpenter tags take precedence over the node labels
+	tags := lo.Assign(nodeTags(ctx, node), c.instanceProvider.Tags(nodeClass, nodeClaim))
+	// NodeClaims annotated with the NodeClass tags only are migrated, removing the NodeClass tags
+	// recorded there as well
+	applied := lo.Assign(
+		instance.ParseTags(nodeClaim.Annotations[v1.AnnotationNodeClassTags]),
+		instance.ParseTags(nodeClaim.Annotations[v1.AnnotationInstanceTags]),
+	)
+	if err := c.instanceProvider.UpdateTags(ctx, id, tags, lo.Keys(applied)); err != nil {
+		if cloudprovider.IsNodeClaimNotFoundError(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if node != nil && options.FromContext(ctx).DisplayNameFromNode {
+		if err := c.reconcileDisplayName(ctx, id, node.Name); err != nil {
+			return reconcile.Result{}, cloudprovider.IgnoreNodeClaimNotFoundError(err)
+		}
+	}
+
+	stored := nodeClaim.DeepCopy()
+	nodeClaim.Annotations = lo.Assign(nodeClaim.Annotations, map[string]string{
+		v1.AnnotationInstanceTags: instance.FormatTags(tags),
+	})
+	delete(nodeClaim.Annotations, v1.AnnotationNodeClassTags)
+	if !equality.Semantic.DeepEqual(stored, nodeClaim) {
+		if err := c.kubeClient.Patch(ctx, nodeClaim, client.MergeFrom(stored)); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+	return reconcile.Result{RequeueAfter: repairInterval}, nil
+}
+
+// reconcileDisplayName sets the display name of the instance to the node name
+func (c *Controller) reconcileDisplayName(ctx context.Context, id, nodeName string) error {
+	inst, err := c.instanceProvider.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if inst.DisplayName == nodeName {
+		return nil
+	}
+	return c.instanceProvider.UpdateDisplayName(ctx, id, nodeName)
+}
+
+// nodeTags returns the tags of a registered node: its name, and the labels selected by NODE_LABEL_TAGS.
+// CloudStack doesn't support empty tag values, so labels without a value are left out.
+func nodeTags(ctx context.Context, node *corev1.Node) map[string]string {
+	if node == nil {
+		return nil
+	}
+	tags := map[string]string{
+		v1.NodeNameTagKey: node.Name,
+	}
+	for _, key := range options.FromContext(ctx).NodeLabelTags {
+		if value := node.Labels[key]; value != "" {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// nodeHandler enqueues the NodeClaim of the node, so that label changes are propagated to the instance
+func (c *Controller) nodeHandler() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
+		providerID := o.(*corev1.Node).Spec.ProviderID
+		if providerID == "" {
+			return nil
+		}
+		nodeClaims := &karpv1.NodeClaimList{}
+		if err := c.kubeClient.List(ctx, nodeClaims, nodeclaimutils.ForProviderID(providerID)); err != nil {
+			return nil
+		}
+		return lo.Map(nodeClaims.Items, func(n karpv1.NodeClaim, _ int) reconcile.Request {
+			return reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&n)}
+		})
+	})
+}
+
+// nodeClassHandler enqueues the NodeClaims of the NodeClass, so that tag changes are propagated to
+// the instances
+func (c *Controller) nodeClassHandler() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
+		nodeClaims := &karpv1.NodeClaimList{}
+		if err := c.kubeClient.List(ctx, nodeClaims, nodeclaimutils.ForNodeClass(o.(*v1.CloudStackNodeClass))); err != nil {
+			return nil
+		}
+		return lo.Map(nodeClaims.Items, func(n karpv1.NodeClaim, _ int) reconcile.Request {
+			return reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&n)}
+		})
+	})
+}
+
+// isCloudStackNodeClassRef returns whether the reference is to a CloudStackNodeClass
+func isCloudStackNodeClassRef(ref *karpv1.NodeClassReference) bool {
+	return ref != nil && ref.Group == v1.SchemeGroupVersion.Group && ref.Kind == "CloudStackNodeClass"
+}
+
+// Register registers the controller with the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.NewControllerManagedBy(m).
+		Named(controllerName).
+		// NodeClaims are tagged once launched, again once their node registers, and when their NodePool
+		// changes. Other status updates don't change the tags.
+		For(&karpv1.NodeClaim{}, builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldNodeClaim, newNodeClaim := e.ObjectOld.(*karpv1.NodeClaim), e.ObjectNew.(*karpv1.NodeClaim)
+				return oldNodeClaim.Status.ProviderID != newNodeClaim.Status.ProviderID ||
+					oldNodeClaim.Status.NodeName != newNodeClaim.Status.NodeName ||
+					oldNodeClaim.Labels[karpv1.NodePoolLabelKey] != newNodeClaim.Labels[karpv1.NodePoolLabelKey]
+			},
+		})).
+		Watches(&corev1.Node{}, c.nodeHandler(), builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return !equality.Semantic.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+			},
+		})).
+		Watches(&v1.CloudStackNodeClass{}, c.nodeClassHandler(), builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return !equality.Semantic.DeepEqual(e.ObjectOld.(*v1.CloudStackNodeClass).Spec.Tags, e.ObjectNew.(*v1.CloudStackNodeClass).Spec.Tags)
+			},
+		})).
+		WithOptions(controller.Options{
+			RateLimiter:             reasonable.RateLimiter(),
+			MaxConcurrentReconciles: 10,
+		}).
+		Complete(c)
+}
@@ -0,0 +1,211 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tagging
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/apis"
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instance"
+)
+
+func init() {
+	lo.Must0(apis.AddToScheme(scheme.Scheme))
+}
+
+const providerID = "cloudstack://zone-a/vm-1"
+
+// fakeInstanceProvider tags instances with the Karpenter tags given, and records the updates of
+// the tags and display name of the instance
+type fakeInstanceProvider struct {
+	instance.Provider
+	tags        map[string]string
+	instance    *instance.Instance
+	updateErr   error
+	updated     map[string]string
+	removed     []string
+	displayName string
+}
+
+func (f *fakeInstanceProvider) Tags(*v1.CloudStackNodeClass, *karpv1.NodeClaim) map[string]string {
+	return maps.Clone(f.tags)
+}
+
+func (f *fakeInstanceProvider) UpdateTags(_ context.Context, _ string, tags map[string]string, removed []string) error {
+	if f.updateErr != nil {
+		return f.updateErr
+	}
+	f.updated = tags
+	f.removed = removed
+	return nil
+}
+
+func (f *fakeInstanceProvider) Get(context.Context, string) (*instance.Instance, error) {
+	return f.instance, nil
+}
+
+func (f *fakeInstanceProvider) UpdateDisplayName(_ context.Context, _ string, displayName string) error {
+	f.displayName = displayName
+	return nil
+}
+
+func TestReconcile(t *testing.T) {
+	karpenterTags := map[string]string{v1.ManagedByTagKey: "karpenter", v1.NodeClaimTagKey: "default-abcde", "team": "a"}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{
+			corev1.LabelTopologyZone: "zone-a",
+			"workload":               "",
+			v1.ManagedByTagKey:       "node",
+		}},
+		Spec: corev1.NodeSpec{ProviderID: providerID},
+	}
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		node            *corev1.Node
+		opts            options.Options
+		instance        *instance.Instance
+		updateErr       error
+		wantTags        map[string]string
+		wantRemoved     []string
+		wantDisplayName string
+		wantAnnotations map[string]string
+	}{
+		{
+			name:            "NodeClaim without a node",
+			wantTags:        karpenterTags,
+			wantAnnotations: map[string]string{v1.AnnotationInstanceTags: instance.FormatTags(karpenterTags)},
+		},
+		{
+			name:            "tags removed since they were applied",
+			annotations:     map[string]string{v1.AnnotationInstanceTags: instance.FormatTags(map[string]string{v1.ManagedByTagKey: "karpenter", "team": "b", "env": "prod"})},
+			wantTags:        karpenterTags,
+			wantRemoved:     []string{"env", v1.ManagedByTagKey, "team"},
+			wantAnnotations: map[string]string{v1.AnnotationInstanceTags: instance.FormatTags(karpenterTags)},
+		},
+		{
+			name: "NodeClaims annotated with the NodeClass tags are migrated",
+			annotations: map[string]string{
+				v1.AnnotationNodeClassTags: instance.FormatTags(map[string]string{"env": "prod"}),
+				"other":                    "annotation",
+			},
+			wantTags:        karpenterTags,
+			wantRemoved:     []string{"env"},
+			wantAnnotations: map[string]string{v1.AnnotationInstanceTags: instance.FormatTags(karpenterTags), "other": "annotation"},
+		},
+		{
+			name: "node name and selected node labels with a value, under the Karpenter tags",
+			node: node,
+			opts: options.Options{NodeLabelTags: []string{corev1.LabelTopologyZone, "workload", v1.ManagedByTagKey, "missing"}},
+			wantTags: lo.Assign(karpenterTags, map[string]string{
+				v1.NodeNameTagKey:        "node-1",
+				corev1.LabelTopologyZone: "zone-a",
+			}),
+			wantAnnotations: map[string]string{v1.AnnotationInstanceTags: instance.FormatTags(lo.Assign(karpenterTags, map[string]string{
+				v1.NodeNameTagKey:        "node-1",
+				corev1.LabelTopologyZone: "zone-a",
+			}))},
+		},
+		{
+			name:            "display name set to the node name",
+			node:            node,
+			opts:            options.Options{DisplayNameFromNode: true},
+			instance:        &instance.Instance{ID: "vm-1", DisplayName: "karpenter-default-abcde"},
+			wantTags:        lo.Assign(karpenterTags, map[string]string{v1.NodeNameTagKey: "node-1"}),
+			wantDisplayName: "node-1",
+			wantAnnotations: map[string]string{v1.AnnotationInstanceTags: instance.FormatTags(lo.Assign(karpenterTags, map[string]string{v1.NodeNameTagKey: "node-1"}))},
+		},
+		{
+			name:            "display name already set",
+			node:            node,
+			opts:            options.Options{DisplayNameFromNode: true},
+			instance:        &instance.Instance{ID: "vm-1", DisplayName: "node-1"},
+			wantTags:        lo.Assign(karpenterTags, map[string]string{v1.NodeNameTagKey: "node-1"}),
+			wantAnnotations: map[string]string{v1.AnnotationInstanceTags: instance.FormatTags(lo.Assign(karpenterTags, map[string]string{v1.NodeNameTagKey: "node-1"}))},
+		},
+		{
+			name:            "display name left alone without the option",
+			node:            node,
+			instance:        &instance.Instance{ID: "vm-1", DisplayName: "karpenter-default-abcde"},
+			wantTags:        lo.Assign(karpenterTags, map[string]string{v1.NodeNameTagKey: "node-1"}),
+			wantAnnotations: map[string]string{v1.AnnotationInstanceTags: instance.FormatTags(lo.Assign(karpenterTags, map[string]string{v1.NodeNameTagKey: "node-1"}))},
+		},
+		{
+			name:      "instance not found",
+			updateErr: cloudprovider.NewNodeClaimNotFoundError(fmt.Errorf("instance vm-1 not found")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := options.ToContext(context.Background(), &tt.opts)
+			nodeClaim := &karpv1.NodeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "default-abcde", Annotations: tt.annotations},
+				Spec: karpv1.NodeClaimSpec{NodeClassRef: &karpv1.NodeClassReference{
+					Group: v1.SchemeGroupVersion.Group,
+					Kind:  "CloudStackNodeClass",
+					Name:  "default",
+				}},
+				Status: karpv1.NodeClaimStatus{ProviderID: providerID},
+			}
+			objects := []client.Object{nodeClaim, &v1.CloudStackNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}
+			if tt.node != nil {
+				objects = append(objects, tt.node)
+			}
+			kubeClient := fake.NewClientBuilder().
+				WithObjects(objects...).
+				WithIndex(&corev1.Node{}, "spec.providerID", func(o client.Object) []string {
+					return []string{o.(*corev1.Node).Spec.ProviderID}
+				}).
+				Build()
+			instanceProvider := &fakeInstanceProvider{tags: karpenterTags, instance: tt.instance, updateErr: tt.updateErr}
+
+			if _, err := NewController(kubeClient, instanceProvider).Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(nodeClaim)}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if !maps.Equal(instanceProvider.updated, tt.wantTags) {
+				t.Errorf("UpdateTags() tags = %v, want %v", instanceProvider.updated, tt.wantTags)
+			}
+			if removed := slices.Sorted(slices.Values(instanceProvider.removed)); !slices.Equal(removed, tt.wantRemoved) {
+				t.Errorf("UpdateTags() removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			if instanceProvider.displayName != tt.wantDisplayName {
+				t.Errorf("UpdateDisplayName() = %q, want %q", instanceProvider.displayName, tt.wantDisplayName)
+			}
+			stored := &karpv1.NodeClaim{}
+			if err := kubeClient.Get(ctx, client.ObjectKeyFromObject(nodeClaim), stored); err != nil {
+				t.Fatalf("getting nodeclaim: %v", err)
+			}
+			if !maps.Equal(stored.Annotations, tt.wantAnnotations) {
+				t.Errorf("annotations = %v, want %v", stored.Annotations, tt.wantAnnotations)
+			}
+		})
+	}
+}
@@ -16,24 +16,41 @@ package nodeclass
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/apache/cloudstack-go/v2/cloudstack"
 	"github.com/awslabs/operatorpkg/reasonable"
-	"github.com/awslabs/operatorpkg/status"
 	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
 	"sigs.k8s.io/karpenter/pkg/events"
+	nodeclaimutils "sigs.k8s.io/karpenter/pkg/utils/nodeclaim"
+	nodepoolutils "sigs.k8s.io/karpenter/pkg/utils/nodepool"
 
 	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/bootstraptoken"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instancetype"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/network"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/sshkeypair"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/userdata"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
 )
 
@@ -43,11 +60,17 @@ const (
 
 // Controller is the NodeClass controller
 type Controller struct {
-	kubeClient       client.Client
-	recorder         events.Recorder
-	zoneProvider     zone.Provider
-	networkProvider  network.Provider
-	templateProvider template.Provider
+	kubeClient             client.Client
+	recorder               events.Recorder
+	zoneProvider           zone.Provider
+	networkProvider        network.Provider
+	templateProvider       template.Provider
+	instanceTypeProvider   instancetype.Provider
+	diskOfferingProvider   diskoffering.Provider
+	sshKeyPairProvider     sshkeypair.Provider
+	userDataProvider       userdata.Provider
+	bootstrapTokenProvider bootstraptoken.Provider
+	nodeClassEvents        <-chan event.GenericEvent
 }
 
 // NewController creates a new NodeClass controller
@@ -57,17 +80,30 @@ func NewController(
 	zoneProvider zone.Provider,
 	networkProvider network.Provider,
 	templateProvider template.Provider,
+	instanceTypeProvider instancetype.Provider,
+	diskOfferingProvider diskoffering.Provider,
+	sshKeyPairProvider sshkeypair.Provider,
+	userDataProvider userdata.Provider,
+	bootstrapTokenProvider bootstraptoken.Provider,
+	nodeClassEvents <-chan event.GenericEvent,
 ) *Controller {
 	return &Controller{
-		kubeClient:       kubeClient,
-		recorder:         recorder,
-		zoneProvider:     zoneProvider,
-		networkProvider:  networkProvider,
-		templateProvider: templateProvider,
+		kubeClient:             kubeClient,
+		recorder:               recorder,
+		zoneProvider:           zoneProvider,
+		networkProvider:        networkProvider,
+		templateProvider:       templateProvider,
+		instanceTypeProvider:   instanceTypeProvider,
+		diskOfferingProvider:   diskOfferingProvider,
+		sshKeyPairProvider:     sshKeyPairProvider,
+		userDataProvider:       userDataProvider,
+		bootstrapTokenProvider: bootstrapTokenProvider,
+		nodeClassEvents:        nodeClassEvents,
 	}
 }
 
-// Reconcile reconciles a NodeClass
+// Reconcile reconciles a NodeClass. Each part of the NodeClass is validated in its own status
+// condition, so that all of its problems are reported at once, and Ready is computed from them.
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	logger := log.FromContext(ctx).WithValues("nodeclass", req.Name)
 	ctx = log.IntoContext(ctx, logger)
@@ -78,50 +114,110 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		return reconcile.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// Skip if being deleted
 	if !nodeClass.DeletionTimestamp.IsZero() {
-		return reconcile.Result{}, nil
+		return c.finalize(ctx, nodeClass)
 	}
 
-	// Validate zone
-	if _, err := c.zoneProvider.GetByName(ctx, nodeClass.Spec.Zone); err != nil {
-		c.setCondition(nodeClass, status.Condition{
-			Type:    "Ready",
-			Status:  metav1.ConditionFalse,
-			Reason:  "ZoneValidationFailed",
-			Message: fmt.Sprintf("Zone validation failed: %v", err),
-		})
-		_ = c.kubeClient.Status().Update(ctx, nodeClass)
-		return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
+	// Hold the deletion of the NodeClass until its NodeClaims are terminated
+	if !controllerutil.ContainsFinalizer(nodeClass, v1.TerminationFinalizer) {
+		stored := nodeClass.DeepCopy()
+		controllerutil.AddFinalizer(nodeClass, v1.TerminationFinalizer)
+		if err := c.kubeClient.Patch(ctx, nodeClass, client.MergeFromWithOptions(stored, client.MergeFromWithOptimisticLock{})); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
 	}
 
-	// Resolve networks
-	networks, err := c.networkProvider.ResolveNetworks(ctx, nodeClass.Spec.NetworkSelectorTerms, nodeClass.Spec.Zone)
-	if err != nil {
-		c.setCondition(nodeClass, status.Condition{
-			Type:    "Ready",
-			Status:  metav1.ConditionFalse,
-			Reason:  "NetworkResolutionFailed",
-			Message: fmt.Sprintf("Network resolution failed: %v", err),
-		})
-		_ = c.kubeClient.Status().Update(ctx, nodeClass)
+	c.setCondition(nodeClass, v1.ConditionTypeZoneReady, "ZoneValidationFailed", "Zone validation failed",
+		c.validateZone(ctx, nodeClass))
+	c.setCondition(nodeClass, v1.ConditionTypeNetworksReady, "NetworkResolutionFailed", "Network resolution failed",
+		c.reconcileNetworks(ctx, nodeClass))
+	c.setCondition(nodeClass, v1.ConditionTypeTemplatesReady, "TemplateResolutionFailed", "Template resolution failed",
+		c.reconcileTemplates(ctx, nodeClass))
+	c.setCondition(nodeClass, v1.ConditionTypeServiceOfferingsReady, "ServiceOfferingResolutionFailed", "Service offering resolution failed",
+		c.reconcileServiceOfferings(ctx, nodeClass))
+	c.setCondition(nodeClass, v1.ConditionTypeDiskOfferingsReady, "DiskOfferingValidationFailed", "Disk offering validation failed",
+		c.reconcileDiskOfferings(ctx, nodeClass))
+	c.setCondition(nodeClass, v1.ConditionTypeSSHKeyPairReady, "SSHKeyPairValidationFailed", "SSH key pair validation failed",
+		c.validateSSHKeyPair(ctx, nodeClass))
+	err := c.reconcileUserData(ctx, nodeClass)
+	c.setCondition(nodeClass, v1.ConditionTypeUserDataValid,
+		lo.Ternary(errors.Is(err, userdata.ErrTooLarge), "UserDataTooLarge", "UserDataValidationFailed"), "User data validation failed", err)
+	c.setCondition(nodeClass, v1.ConditionTypeKubeletValid, "KubeletValidationFailed", "Kubelet configuration validation failed",
+		validateKubelet(nodeClass.Spec.Kubelet))
+
+	// Update status
+	if err := c.kubeClient.Status().Update(ctx, nodeClass); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := c.reconcileHash(ctx, nodeClass); err != nil {
+		return reconcile.Result{}, fmt.Errorf("reconciling hash: %w", err)
+	}
+
+	if !nodeClass.StatusConditions().Root().IsTrue() {
+		logger.Info("NodeClass is not ready", "reason", nodeClass.StatusConditions().Root().Message)
 		return reconcile.Result{RequeueAfter: 1 * time.Minute}, nil
 	}
 
-	// Resolve templates
-	templates, err := c.templateProvider.ResolveTemplates(ctx, nodeClass.Spec.TemplateSelectorTerms, nodeClass.Spec.Zone)
-	if err != nil {
-		c.setCondition(nodeClass, status.Condition{
-			Type:    "Ready",
-			Status:  metav1.ConditionFalse,
-			Reason:  "TemplateResolutionFailed",
-			Message: fmt.Sprintf("Template resolution failed: %v", err),
-		})
-		_ = c.kubeClient.Status().Update(ctx, nodeClass)
+	logger.Info("Reconciled NodeClass successfully",
+		"networks", len(nodeClass.Status.Networks),
+		"templates", len(nodeClass.Status.Templates),
+		"serviceOfferings", len(nodeClass.Status.ServiceOfferings))
+
+	// Requeue after some time to refresh cache
+	return reconcile.Result{RequeueAfter: 15 * time.Minute}, nil
+}
+
+// finalize removes the termination finalizer once no NodeClaims or NodePools reference the NodeClass,
+// so that deleting a NodeClass doesn't orphan its nodes from drift and garbage collection. The bootstrap
+// tokens minted for the NodeClass are deleted along with it.
+func (c *Controller) finalize(ctx context.Context, nodeClass *v1.CloudStackNodeClass) (reconcile.Result, error) {
+	if !controllerutil.ContainsFinalizer(nodeClass, v1.TerminationFinalizer) {
+		return reconcile.Result{}, nil
+	}
+
+	nodeClaims := &karpv1.NodeClaimList{}
+	if err := c.kubeClient.List(ctx, nodeClaims, nodeclaimutils.ForNodeClass(nodeClass)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodeclaims: %w", err)
+	}
+	if len(nodeClaims.Items) > 0 {
+		c.recorder.Publish(WaitingOnNodeClaimTerminationEvent(nodeClass, lo.Map(nodeClaims.Items, func(n karpv1.NodeClaim, _ int) string { return n.Name })))
+		return reconcile.Result{RequeueAfter: 1 * time.Minute}, nil
+	}
+	nodePools := &karpv1.NodePoolList{}
+	if err := c.kubeClient.List(ctx, nodePools, nodepoolutils.ForNodeClass(nodeClass)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodepools: %w", err)
+	}
+	if len(nodePools.Items) > 0 {
+		c.recorder.Publish(WaitingOnNodePoolDeletionEvent(nodeClass, lo.Map(nodePools.Items, func(n karpv1.NodePool, _ int) string { return n.Name })))
 		return reconcile.Result{RequeueAfter: 1 * time.Minute}, nil
 	}
 
-	// Update status
+	if err := c.bootstrapTokenProvider.DeleteForNodeClass(ctx, nodeClass.Name); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	stored := nodeClass.DeepCopy()
+	controllerutil.RemoveFinalizer(nodeClass, v1.TerminationFinalizer)
+	if err := c.kubeClient.Patch(ctx, nodeClass, client.MergeFromWithOptions(stored, client.MergeFromWithOptimisticLock{})); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	log.FromContext(ctx).Info("Removed termination finalizer")
+	return reconcile.Result{}, nil
+}
+
+// validateZone validates that the zone exists
+func (c *Controller) validateZone(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	_, err := c.zoneProvider.GetByName(ctx, nodeClass.Spec.Zone)
+	return err
+}
+
+// reconcileNetworks resolves the networks into the status
+func (c *Controller) reconcileNetworks(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	networks, err := c.networkProvider.ResolveNetworks(ctx, nodeClass.Spec.NetworkSelectorTerms, nodeClass.Spec.Zone)
+	if err != nil {
+		return err
+	}
 	nodeClass.Status.Networks = lo.Map(networks, func(n *network.Network, _ int) v1.Network {
 		return v1.Network{
 			ID:   n.ID,
@@ -130,43 +226,212 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 			Type: n.Type,
 		}
 	})
+	return nil
+}
 
-	nodeClass.Status.Templates = lo.Map(templates, func(t *template.Template, _ int) v1.Template {
-		return v1.Template{
-			ID:     t.ID,
-			Name:   t.Name,
-			OSType: t.OSTypeName,
-			Zone:   t.Zone,
+// reconcileTemplates resolves the templates into the status, reporting the templates still being
+// copied into the zone
+func (c *Controller) reconcileTemplates(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	templates, err := c.templateProvider.MatchTemplates(ctx, nodeClass.Spec.TemplateSelectorTerms, nodeClass.Spec.Zone)
+	if err != nil {
+		return err
+	}
+	nodeClass.Status.Templates = statusTemplates(templates)
+	if len(templates) == 0 {
+		return fmt.Errorf("no templates matched the selector terms in zone %s", nodeClass.Spec.Zone)
+	}
+	if !lo.ContainsBy(templates, (*template.Template).Ready) {
+		return fmt.Errorf("none of the %d templates matching the selector terms are ready in zone %s", len(templates), nodeClass.Spec.Zone)
+	}
+	return nil
+}
+
+// reconcileServiceOfferings resolves the service offerings into the status, with the selector logic
+// of the instance types offered to Karpenter
+func (c *Controller) reconcileServiceOfferings(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	serviceOfferings, err := c.instanceTypeProvider.ResolveServiceOfferings(ctx, nodeClass)
+	if err != nil {
+		return err
+	}
+	nodeClass.Status.ServiceOfferings = lo.Map(serviceOfferings, func(o *cloudstack.ServiceOffering, _ int) v1.ServiceOffering {
+		return v1.ServiceOffering{
+			ID:          o.Id,
+			Name:        o.Name,
+			CPUNumber:   o.Cpunumber,
+			CPUSpeed:    o.Cpuspeed,
+			Memory:      o.Memory,
+			NetworkRate: o.Networkrate,
 		}
 	})
+	if len(serviceOfferings) == 0 {
+		return fmt.Errorf("no service offerings matched the selector terms")
+	}
+	return nil
+}
 
-	// Set Ready condition
-	c.setCondition(nodeClass, status.Condition{
-		Type:    "Ready",
-		Status:  metav1.ConditionTrue,
-		Reason:  "Ready",
-		Message: "NodeClass is ready",
-	})
+// reconcileDiskOfferings resolves and validates the root and data disk offerings into the status,
+// and validates the disk offerings of the block device mappings
+func (c *Controller) reconcileDiskOfferings(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	var rootDiskOffering *diskoffering.DiskOffering
+	if nodeClass.Spec.RootDiskOffering != nil {
+		var err error
+		rootDiskOffering, err = c.diskOfferingProvider.ResolveRootDiskOffering(ctx, nodeClass.Spec.RootDiskOffering, nodeClass.Spec.Zone)
+		if err != nil {
+			return fmt.Errorf("root disk offering: %w", err)
+		}
+		if rootDiskOffering.IsCustomized && nodeClass.Spec.RootDiskSize == nil {
+			return fmt.Errorf("root disk offering %s is customized and requires rootDiskSize", rootDiskOffering.Name)
+		}
+	}
 
-	// Update status
-	if err := c.kubeClient.Status().Update(ctx, nodeClass); err != nil {
-		return reconcile.Result{}, err
+	var diskOffering *diskoffering.DiskOffering
+	if nodeClass.Spec.DiskOffering != nil {
+		var err error
+		diskOffering, err = c.diskOfferingProvider.Resolve(ctx, *nodeClass.Spec.DiskOffering, nodeClass.Spec.Zone)
+		if err == nil {
+			err = diskoffering.Validate(diskOffering, nodeClass.Spec.DataDiskSize, nodeClass.Spec.DataDiskMinIOPS, nodeClass.Spec.DataDiskMaxIOPS)
+		}
+		if err != nil {
+			return fmt.Errorf("data disk offering: %w", err)
+		}
 	}
 
-	logger.Info("Reconciled NodeClass successfully",
-		"networks", len(networks),
-		"templates", len(templates))
+	for i, mapping := range nodeClass.Spec.BlockDeviceMappings {
+		offering, err := c.diskOfferingProvider.Resolve(ctx, mapping.DiskOffering, nodeClass.Spec.Zone)
+		if err == nil {
+			err = diskoffering.Validate(offering, mapping.Size, mapping.MinIOPS, mapping.MaxIOPS)
+		}
+		if err != nil {
+			return fmt.Errorf("block device mapping %d: %w", i, err)
+		}
+	}
 
-	// Requeue after some time to refresh cache
-	return reconcile.Result{RequeueAfter: 15 * time.Minute}, nil
+	nodeClass.Status.RootDiskOffering = nil
+	if rootDiskOffering != nil {
+		nodeClass.Status.RootDiskOffering = &v1.DiskOffering{
+			ID:               rootDiskOffering.ID,
+			Name:             rootDiskOffering.Name,
+			DiskSize:         lo.FromPtrOr(nodeClass.Spec.RootDiskSize, rootDiskOffering.DiskSize),
+			Customized:       rootDiskOffering.IsCustomized,
+			CustomizedIOPS:   rootDiskOffering.IsCustomizedIOPS,
+			StorageTags:      rootDiskOffering.StorageTags,
+			ProvisioningType: rootDiskOffering.ProvisioningType,
+		}
+	}
+
+	nodeClass.Status.DiskOffering = nil
+	if diskOffering != nil {
+		nodeClass.Status.DiskOffering = &v1.DiskOffering{
+			ID:               diskOffering.ID,
+			Name:             diskOffering.Name,
+			DiskSize:         lo.FromPtrOr(nodeClass.Spec.DataDiskSize, diskOffering.DiskSize),
+			Customized:       diskOffering.IsCustomized,
+			CustomizedIOPS:   diskOffering.IsCustomizedIOPS,
+			StorageTags:      diskOffering.StorageTags,
+			ProvisioningType: diskOffering.ProvisioningType,
+		}
+	}
+	return nil
 }
 
-// setCondition sets a condition on the NodeClass
-func (c *Controller) setCondition(nodeClass *v1.CloudStackNodeClass, condition status.Condition) {
-	condition.LastTransitionTime = metav1.Now()
-	condition.ObservedGeneration = nodeClass.Generation
+// validateSSHKeyPair validates that the SSH key pair exists
+func (c *Controller) validateSSHKeyPair(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	if nodeClass.Spec.SSHKeyPair == nil {
+		return nil
+	}
+	_, err := c.sshKeyPairProvider.Get(ctx, *nodeClass.Spec.SSHKeyPair)
+	return err
+}
 
-	nodeClass.SetCondition(condition)
+// reconcileUserData validates the user data template and bootstrap configuration, and hashes the
+// referenced user data into the status so that updating it drifts the nodes. The previous hash is kept
+// when the user data fails to resolve, so that a transient failure doesn't drift the nodes.
+func (c *Controller) reconcileUserData(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	if err := c.userDataProvider.Validate(ctx, nodeClass); err != nil {
+		return err
+	}
+	if nodeClass.Spec.UserDataFrom == nil {
+		nodeClass.Status.UserDataHash = ""
+		return nil
+	}
+	userData, err := c.userDataProvider.Resolve(ctx, nodeClass)
+	if err != nil {
+		return err
+	}
+	nodeClass.Status.UserDataHash = userdata.Hash(userData)
+	return nil
+}
+
+// validateKubelet checks that the kubelet reserved resources are quantities
+// and that the eviction thresholds are percentages or quantities
+func validateKubelet(kubelet *v1.KubeletConfiguration) error {
+	if kubelet == nil {
+		return nil
+	}
+	for name, reserved := range map[string]map[string]string{
+		"kubeReserved":   kubelet.KubeReserved,
+		"systemReserved": kubelet.SystemReserved,
+	} {
+		for key, value := range reserved {
+			if _, err := resource.ParseQuantity(value); err != nil {
+				return fmt.Errorf("%s[%s]: invalid quantity %q: %w", name, key, value, err)
+			}
+		}
+	}
+	for name, thresholds := range map[string]map[string]string{
+		"evictionHard": kubelet.EvictionHard,
+		"evictionSoft": kubelet.EvictionSoft,
+	} {
+		for signal, threshold := range thresholds {
+			if err := options.ValidateThreshold(threshold); err != nil {
+				return fmt.Errorf("%s[%s]: %w", name, signal, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setCondition sets a status condition to true, or to false with the reason and the error
+func (c *Controller) setCondition(nodeClass *v1.CloudStackNodeClass, conditionType, reason, message string, err error) {
+	if err != nil {
+		nodeClass.StatusConditions().SetFalse(conditionType, reason, fmt.Sprintf("%s: %v", message, err))
+		return
+	}
+	nodeClass.StatusConditions().SetTrue(conditionType)
+}
+
+// userDataSourceHandler enqueues the NodeClasses whose UserDataFrom references the object
+func (c *Controller) userDataSourceHandler(ref func(*v1.UserDataSource) *v1.KeyReference) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
+		nodeClasses := &v1.CloudStackNodeClassList{}
+		if err := c.kubeClient.List(ctx, nodeClasses); err != nil {
+			return nil
+		}
+		return lo.FilterMap(nodeClasses.Items, func(nodeClass v1.CloudStackNodeClass, _ int) (reconcile.Request, bool) {
+			if nodeClass.Spec.UserDataFrom == nil {
+				return reconcile.Request{}, false
+			}
+			r := ref(nodeClass.Spec.UserDataFrom)
+			return reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&nodeClass)},
+				r != nil && r.Namespace == o.GetNamespace() && r.Name == o.GetName()
+		})
+	})
+}
+
+// nodeClassTerminationHandler enqueues the deleted NodeClass referenced by the NodeClaim or NodePool,
+// so that its finalizer is removed as soon as it's no longer referenced
+func (c *Controller) nodeClassTerminationHandler(ref func(client.Object) *karpv1.NodeClassReference) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
+		nodeClassRef := ref(o)
+		if nodeClassRef == nil || nodeClassRef.Group != v1.SchemeGroupVersion.Group || nodeClassRef.Kind != "CloudStackNodeClass" {
+			return nil
+		}
+		nodeClass := &v1.CloudStackNodeClass{}
+		if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: nodeClassRef.Name}, nodeClass); err != nil || nodeClass.DeletionTimestamp.IsZero() {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(nodeClass)}}
+	})
 }
 
 // Register registers the controller with the manager
@@ -174,9 +439,37 @@ func (c *Controller) Register(_ context.Context, m manager.Manager) error {
 	return controllerruntime.NewControllerManagedBy(m).
 		Named(controllerName).
 		For(&v1.CloudStackNodeClass{}).
+		// Only the metadata of Secrets and ConfigMaps is watched, so that their data isn't cached
+		WatchesMetadata(&corev1.Secret{}, c.userDataSourceHandler(func(s *v1.UserDataSource) *v1.KeyReference { return s.SecretKeyRef })).
+		WatchesMetadata(&corev1.ConfigMap{}, c.userDataSourceHandler(func(s *v1.UserDataSource) *v1.KeyReference { return s.ConfigMapKeyRef })).
+		Watches(&karpv1.NodeClaim{}, c.nodeClassTerminationHandler(func(o client.Object) *karpv1.NodeClassReference {
+			return o.(*karpv1.NodeClaim).Spec.NodeClassRef
+		})).
+		Watches(&karpv1.NodePool{}, c.nodeClassTerminationHandler(func(o client.Object) *karpv1.NodeClassReference {
+			return o.(*karpv1.NodePool).Spec.Template.Spec.NodeClassRef
+		})).
+		// NodeClasses whose CloudStack resources changed, as found by the CloudStack events controller
+		WatchesRawSource(source.Channel(c.nodeClassEvents, &handler.EnqueueRequestForObject{})).
 		WithOptions(controller.Options{
 			RateLimiter:             reasonable.RateLimiter(),
 			MaxConcurrentReconciles: 10,
 		}).
 		Complete(c)
 }
+
+// statusTemplates converts the matched templates to their status representation
+func statusTemplates(templates []*template.Template) []v1.Template {
+	return lo.Map(templates, func(t *template.Template, _ int) v1.Template {
+		return v1.Template{
+			ID:         t.ID,
+			Name:       t.Name,
+			OSType:     t.OSTypeName,
+			Zone:       t.Zone,
+			Ready:      t.Ready(),
+			Version:    t.Version,
+			Hypervisor: t.Hypervisor,
+			BootType:   t.BootType,
+			Created:    lo.Ternary(t.Created.IsZero(), nil, &metav1.Time{Time: t.Created}),
+		}
+	})
+}
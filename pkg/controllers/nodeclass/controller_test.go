@@ -0,0 +1,511 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeclass
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/samber/lo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/events"
+
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/apis"
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/bootstraptoken"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instancetype"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/network"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/sshkeypair"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/userdata"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
+)
+
+func init() {
+	lo.Must0(apis.AddToScheme(scheme.Scheme))
+}
+
+// fakeZoneProvider knows a single zone
+type fakeZoneProvider struct{ zone.Provider }
+
+func (f *fakeZoneProvider) GetByName(_ context.Context, name string) (*zone.Zone, error) {
+	if name != "zone-a" {
+		return nil, fmt.Errorf("zone %s not found", name)
+	}
+	return &zone.Zone{ID: "zone-a-id", Name: name}, nil
+}
+
+type fakeNetworkProvider struct {
+	network.Provider
+	err error
+}
+
+func (f *fakeNetworkProvider) ResolveNetworks(_ context.Context, _ []v1.NetworkSelectorTerm, zone string) ([]*network.Network, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []*network.Network{{ID: "network-1", Name: "default", Zone: zone, Type: "Isolated"}}, nil
+}
+
+type fakeTemplateProvider struct {
+	template.Provider
+	templates []*template.Template
+}
+
+func (f *fakeTemplateProvider) MatchTemplates(context.Context, []v1.TemplateSelectorTerm, string) ([]*template.Template, error) {
+	return f.templates, nil
+}
+
+type fakeInstanceTypeProvider struct {
+	instancetype.Provider
+	serviceOfferings []*cloudstack.ServiceOffering
+}
+
+func (f *fakeInstanceTypeProvider) ResolveServiceOfferings(context.Context, *v1.CloudStackNodeClass) ([]*cloudstack.ServiceOffering, error) {
+	return f.serviceOfferings, nil
+}
+
+// fakeDiskOfferingProvider resolves the given disk offerings by name
+type fakeDiskOfferingProvider struct {
+	diskoffering.Provider
+	offerings map[string]*diskoffering.DiskOffering
+}
+
+func (f *fakeDiskOfferingProvider) Resolve(_ context.Context, nameOrID string, _ string) (*diskoffering.DiskOffering, error) {
+	if offering, ok := f.offerings[nameOrID]; ok {
+		return offering, nil
+	}
+	return nil, fmt.Errorf("disk offering %s not found", nameOrID)
+}
+
+func (f *fakeDiskOfferingProvider) ResolveRootDiskOffering(ctx context.Context, selector *v1.RootDiskOfferingSelector, zone string) (*diskoffering.DiskOffering, error) {
+	return f.Resolve(ctx, selector.Name, zone)
+}
+
+// fakeSSHKeyPairProvider knows a single SSH key pair
+type fakeSSHKeyPairProvider struct{ sshkeypair.Provider }
+
+func (f *fakeSSHKeyPairProvider) Get(_ context.Context, name string) (*sshkeypair.SSHKeyPair, error) {
+	if name != "karpenter" {
+		return nil, fmt.Errorf("SSH key pair %s not found", name)
+	}
+	return &sshkeypair.SSHKeyPair{Name: name}, nil
+}
+
+type fakeUserDataProvider struct {
+	userdata.Provider
+	userData    string
+	validateErr error
+	resolveErr  error
+}
+
+func (f *fakeUserDataProvider) Validate(context.Context, *v1.CloudStackNodeClass) error {
+	return f.validateErr
+}
+
+func (f *fakeUserDataProvider) Resolve(context.Context, *v1.CloudStackNodeClass) (string, error) {
+	return f.userData, f.resolveErr
+}
+
+// fakeBootstrapTokenProvider records the NodeClasses whose bootstrap tokens were deleted
+type fakeBootstrapTokenProvider struct {
+	bootstraptoken.Provider
+	deleted []string
+}
+
+func (f *fakeBootstrapTokenProvider) DeleteForNodeClass(_ context.Context, nodeClassName string) error {
+	f.deleted = append(f.deleted, nodeClassName)
+	return nil
+}
+
+// fakeRecorder records the reasons of the events published
+type fakeRecorder struct {
+	reasons []string
+}
+
+func (f *fakeRecorder) Publish(evts ...events.Event) {
+	for _, evt := range evts {
+		f.reasons = append(f.reasons, evt.Reason)
+	}
+}
+
+// testProviders are the providers of the controller under test, resolving a valid NodeClass
+type testProviders struct {
+	network        *fakeNetworkProvider
+	template       *fakeTemplateProvider
+	instanceType   *fakeInstanceTypeProvider
+	diskOffering   *fakeDiskOfferingProvider
+	userData       *fakeUserDataProvider
+	bootstrapToken *fakeBootstrapTokenProvider
+}
+
+func newTestProviders() *testProviders {
+	return &testProviders{
+		network: &fakeNetworkProvider{},
+		template: &fakeTemplateProvider{templates: []*template.Template{
+			{ID: "template-1", Name: "ubuntu", Zone: "zone-a", IsReady: true, Status: "Download Complete"},
+		}},
+		instanceType: &fakeInstanceTypeProvider{serviceOfferings: []*cloudstack.ServiceOffering{
+			{Id: "offering-1", Name: "medium", Cpunumber: 2, Memory: 4096},
+		}},
+		diskOffering: &fakeDiskOfferingProvider{offerings: map[string]*diskoffering.DiskOffering{
+			"fixed":  {ID: "disk-1", Name: "fixed", DiskSize: 100},
+			"custom": {ID: "disk-2", Name: "custom", IsCustomized: true},
+		}},
+		userData:       &fakeUserDataProvider{},
+		bootstrapToken: &fakeBootstrapTokenProvider{},
+	}
+}
+
+func newTestController(kubeClient client.Client, recorder events.Recorder, p *testProviders) *Controller {
+	return NewController(kubeClient, recorder, &fakeZoneProvider{}, p.network, p.template, p.instanceType,
+		p.diskOffering, &fakeSSHKeyPairProvider{}, p.userData, p.bootstrapToken, nil)
+}
+
+// newKubeClient returns a fake client holding the objects, with the NodeClaim and NodePool indexes
+// registered by the operator
+func newKubeClient(objects ...client.Object) client.Client {
+	nodeClaimRef := func(o client.Object) *karpv1.NodeClassReference { return o.(*karpv1.NodeClaim).Spec.NodeClassRef }
+	nodePoolRef := func(o client.Object) *karpv1.NodeClassReference {
+		return o.(*karpv1.NodePool).Spec.Template.Spec.NodeClassRef
+	}
+	return fake.NewClientBuilder().
+		WithObjects(objects...).
+		WithStatusSubresource(&v1.CloudStackNodeClass{}).
+		WithIndex(&karpv1.NodeClaim{}, "spec.nodeClassRef.group", func(o client.Object) []string { return []string{nodeClaimRef(o).Group} }).
+		WithIndex(&karpv1.NodeClaim{}, "spec.nodeClassRef.kind", func(o client.Object) []string { return []string{nodeClaimRef(o).Kind} }).
+		WithIndex(&karpv1.NodeClaim{}, "spec.nodeClassRef.name", func(o client.Object) []string { return []string{nodeClaimRef(o).Name} }).
+		WithIndex(&karpv1.NodePool{}, "spec.template.spec.nodeClassRef.group", func(o client.Object) []string { return []string{nodePoolRef(o).Group} }).
+		WithIndex(&karpv1.NodePool{}, "spec.template.spec.nodeClassRef.kind", func(o client.Object) []string { return []string{nodePoolRef(o).Kind} }).
+		WithIndex(&karpv1.NodePool{}, "spec.template.spec.nodeClassRef.name", func(o client.Object) []string { return []string{nodePoolRef(o).Name} }).
+		Build()
+}
+
+func newNodeClass(spec v1.CloudStackNodeClassSpec) *v1.CloudStackNodeClass {
+	return &v1.CloudStackNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: spec}
+}
+
+func TestReconcile(t *testing.T) {
+	valid := v1.CloudStackNodeClassSpec{
+		Zone:         "zone-a",
+		SSHKeyPair:   lo.ToPtr("karpenter"),
+		DiskOffering: lo.ToPtr("fixed"),
+		Kubelet:      &v1.KubeletConfiguration{KubeReserved: map[string]string{"cpu": "100m"}, EvictionHard: map[string]string{"memory.available": "5%"}},
+	}
+	tests := []struct {
+		name      string
+		spec      v1.CloudStackNodeClassSpec
+		providers func(*testProviders)
+		// wantReasons are the reasons of the conditions expected to be false, all the others being true
+		wantReasons map[string]string
+	}{
+		{
+			name: "valid NodeClass",
+			spec: valid,
+		},
+		{
+			name: "zone not found",
+			spec: func() v1.CloudStackNodeClassSpec {
+				spec := valid
+				spec.Zone = "missing"
+				return spec
+			}(),
+			wantReasons: map[string]string{v1.ConditionTypeZoneReady: "ZoneValidationFailed"},
+		},
+		{
+			name:        "networks failing to resolve",
+			spec:        valid,
+			providers:   func(p *testProviders) { p.network.err = fmt.Errorf("no networks matched the selector terms") },
+			wantReasons: map[string]string{v1.ConditionTypeNetworksReady: "NetworkResolutionFailed"},
+		},
+		{
+			name:        "no templates matched",
+			spec:        valid,
+			providers:   func(p *testProviders) { p.template.templates = nil },
+			wantReasons: map[string]string{v1.ConditionTypeTemplatesReady: "TemplateResolutionFailed"},
+		},
+		{
+			name: "templates still being copied into the zone",
+			spec: valid,
+			providers: func(p *testProviders) {
+				p.template.templates = []*template.Template{{ID: "template-1", Zone: "zone-a", Status: "Installing Template"}}
+			},
+			wantReasons: map[string]string{v1.ConditionTypeTemplatesReady: "TemplateResolutionFailed"},
+		},
+		{
+			name:        "no service offerings matched",
+			spec:        valid,
+			providers:   func(p *testProviders) { p.instanceType.serviceOfferings = nil },
+			wantReasons: map[string]string{v1.ConditionTypeServiceOfferingsReady: "ServiceOfferingResolutionFailed"},
+		},
+		{
+			name: "customized root disk offering without a root disk size",
+			spec: func() v1.CloudStackNodeClassSpec {
+				spec := valid
+				spec.RootDiskOffering = &v1.RootDiskOfferingSelector{Name: "custom"}
+				return spec
+			}(),
+			wantReasons: map[string]string{v1.ConditionTypeDiskOfferingsReady: "DiskOfferingValidationFailed"},
+		},
+		{
+			name: "block device mapping with an unknown disk offering",
+			spec: func() v1.CloudStackNodeClassSpec {
+				spec := valid
+				spec.BlockDeviceMappings = []v1.BlockDeviceMapping{{DiskOffering: "missing"}}
+				return spec
+			}(),
+			wantReasons: map[string]string{v1.ConditionTypeDiskOfferingsReady: "DiskOfferingValidationFailed"},
+		},
+		{
+			name: "SSH key pair not found",
+			spec: func() v1.CloudStackNodeClassSpec {
+				spec := valid
+				spec.SSHKeyPair = lo.ToPtr("missing")
+				return spec
+			}(),
+			wantReasons: map[string]string{v1.ConditionTypeSSHKeyPairReady: "SSHKeyPairValidationFailed"},
+		},
+		{
+			name:        "invalid user data",
+			spec:        valid,
+			providers:   func(p *testProviders) { p.userData.validateErr = fmt.Errorf("parsing user data template") },
+			wantReasons: map[string]string{v1.ConditionTypeUserDataValid: "UserDataValidationFailed"},
+		},
+		{
+			name:        "user data too large",
+			spec:        valid,
+			providers:   func(p *testProviders) { p.userData.validateErr = fmt.Errorf("%w: 40000 bytes", userdata.ErrTooLarge) },
+			wantReasons: map[string]string{v1.ConditionTypeUserDataValid: "UserDataTooLarge"},
+		},
+		{
+			name: "invalid kubelet eviction threshold",
+			spec: func() v1.CloudStackNodeClassSpec {
+				spec := valid
+				spec.Kubelet = &v1.KubeletConfiguration{EvictionHard: map[string]string{"memory.available": "lots"}}
+				return spec
+			}(),
+			wantReasons: map[string]string{v1.ConditionTypeKubeletValid: "KubeletValidationFailed"},
+		},
+		{
+			name: "every problem reported at once",
+			spec: func() v1.CloudStackNodeClassSpec {
+				spec := valid
+				spec.Zone = "missing"
+				spec.SSHKeyPair = lo.ToPtr("missing")
+				return spec
+			}(),
+			providers: func(p *testProviders) { p.instanceType.serviceOfferings = nil },
+			wantReasons: map[string]string{
+				v1.ConditionTypeZoneReady:             "ZoneValidationFailed",
+				v1.ConditionTypeServiceOfferingsReady: "ServiceOfferingResolutionFailed",
+				v1.ConditionTypeSSHKeyPairReady:       "SSHKeyPairValidationFailed",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			nodeClass := newNodeClass(tt.spec)
+			kubeClient := newKubeClient(nodeClass)
+			providers := newTestProviders()
+			if tt.providers != nil {
+				tt.providers(providers)
+			}
+
+			result, err := newTestController(kubeClient, &fakeRecorder{}, providers).Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(nodeClass)})
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			stored := &v1.CloudStackNodeClass{}
+			if err := kubeClient.Get(ctx, client.ObjectKeyFromObject(nodeClass), stored); err != nil {
+				t.Fatalf("getting nodeclass: %v", err)
+			}
+			for _, conditionType := range []string{
+				v1.ConditionTypeZoneReady,
+				v1.ConditionTypeNetworksReady,
+				v1.ConditionTypeTemplatesReady,
+				v1.ConditionTypeServiceOfferingsReady,
+				v1.ConditionTypeDiskOfferingsReady,
+				v1.ConditionTypeSSHKeyPairReady,
+				v1.ConditionTypeUserDataValid,
+				v1.ConditionTypeKubeletValid,
+			} {
+				condition := stored.StatusConditions().Get(conditionType)
+				if condition == nil {
+					t.Errorf("condition %s not set", conditionType)
+					continue
+				}
+				if reason, ok := tt.wantReasons[conditionType]; ok {
+					if !condition.IsFalse() || condition.Reason != reason {
+						t.Errorf("condition %s = %s with reason %q, want False with reason %q", conditionType, condition.Status, condition.Reason, reason)
+					}
+				} else if !condition.IsTrue() {
+					t.Errorf("condition %s = %s (%s), want True", conditionType, condition.Status, condition.Message)
+				}
+			}
+			if ready := stored.StatusConditions().Root().IsTrue(); ready != (len(tt.wantReasons) == 0) {
+				t.Errorf("Ready = %t, want %t", ready, len(tt.wantReasons) == 0)
+			}
+			if want := lo.Ternary(len(tt.wantReasons) == 0, 15*time.Minute, time.Minute); result.RequeueAfter != want {
+				t.Errorf("Reconcile() requeue after = %v, want %v", result.RequeueAfter, want)
+			}
+			if !lo.Contains(stored.Finalizers, v1.TerminationFinalizer) {
+				t.Errorf("finalizers = %v, want %s", stored.Finalizers, v1.TerminationFinalizer)
+			}
+		})
+	}
+}
+
+func TestReconcileUserData(t *testing.T) {
+	userDataFrom := &v1.UserDataSource{SecretKeyRef: &v1.KeyReference{Namespace: "default", Name: "user-data", Key: "userdata"}}
+	tests := []struct {
+		name         string
+		userDataFrom *v1.UserDataSource
+		hash         string
+		validateErr  error
+		resolveErr   error
+		wantHash     string
+		wantErr      bool
+	}{
+		{
+			name:     "inline user data isn't hashed",
+			hash:     "stale",
+			wantHash: "",
+		},
+		{
+			name:         "referenced user data hashed",
+			userDataFrom: userDataFrom,
+			hash:         "stale",
+			wantHash:     userdata.Hash("#!/bin/bash\necho hello"),
+		},
+		{
+			name:         "hash kept when the user data fails to resolve",
+			userDataFrom: userDataFrom,
+			hash:         "previous",
+			resolveErr:   fmt.Errorf("secret default/user-data not found"),
+			wantHash:     "previous",
+			wantErr:      true,
+		},
+		{
+			name:         "hash kept when the user data is invalid",
+			userDataFrom: userDataFrom,
+			hash:         "previous",
+			validateErr:  fmt.Errorf("parsing user data template"),
+			wantHash:     "previous",
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers := newTestProviders()
+			providers.userData = &fakeUserDataProvider{userData: "#!/bin/bash\necho hello", validateErr: tt.validateErr, resolveErr: tt.resolveErr}
+			nodeClass := newNodeClass(v1.CloudStackNodeClassSpec{UserDataFrom: tt.userDataFrom})
+			nodeClass.Status.UserDataHash = tt.hash
+
+			err := newTestController(nil, nil, providers).reconcileUserData(context.Background(), nodeClass)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reconcileUserData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if nodeClass.Status.UserDataHash != tt.wantHash {
+				t.Errorf("user data hash = %q, want %q", nodeClass.Status.UserDataHash, tt.wantHash)
+			}
+		})
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	nodeClassRef := &karpv1.NodeClassReference{Group: v1.SchemeGroupVersion.Group, Kind: "CloudStackNodeClass", Name: "default"}
+	otherRef := &karpv1.NodeClassReference{Group: v1.SchemeGroupVersion.Group, Kind: "CloudStackNodeClass", Name: "other"}
+	nodeClaim := func(name string, ref *karpv1.NodeClassReference) *karpv1.NodeClaim {
+		return &karpv1.NodeClaim{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: karpv1.NodeClaimSpec{NodeClassRef: ref}}
+	}
+	nodePool := func(name string, ref *karpv1.NodeClassReference) *karpv1.NodePool {
+		return &karpv1.NodePool{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       karpv1.NodePoolSpec{Template: karpv1.NodeClaimTemplate{Spec: karpv1.NodeClaimTemplateSpec{NodeClassRef: ref}}},
+		}
+	}
+	tests := []struct {
+		name        string
+		objects     []client.Object
+		wantReasons []string
+		wantDeleted bool
+	}{
+		{
+			name:        "blocked while NodeClaims reference the NodeClass",
+			objects:     []client.Object{nodeClaim("default-abcde", nodeClassRef), nodePool("default", nodeClassRef)},
+			wantReasons: []string{"WaitingOnNodeClaimTermination"},
+		},
+		{
+			name:        "blocked while NodePools reference the NodeClass",
+			objects:     []client.Object{nodePool("default", nodeClassRef)},
+			wantReasons: []string{"WaitingOnNodePoolDeletion"},
+		},
+		{
+			name:        "finalized once no longer referenced",
+			objects:     []client.Object{nodeClaim("other-abcde", otherRef), nodePool("other", otherRef)},
+			wantDeleted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			nodeClass := newNodeClass(v1.CloudStackNodeClassSpec{Zone: "zone-a"})
+			nodeClass.Finalizers = []string{v1.TerminationFinalizer}
+			nodeClass.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			kubeClient := newKubeClient(append(tt.objects, nodeClass)...)
+			providers := newTestProviders()
+			recorder := &fakeRecorder{}
+
+			result, err := newTestController(kubeClient, recorder, providers).Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(nodeClass)})
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if !slices.Equal(recorder.reasons, tt.wantReasons) {
+				t.Errorf("published events %v, want %v", recorder.reasons, tt.wantReasons)
+			}
+			err = kubeClient.Get(ctx, client.ObjectKeyFromObject(nodeClass), &v1.CloudStackNodeClass{})
+			if tt.wantDeleted {
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("getting nodeclass error = %v, want not found once the finalizer is removed", err)
+				}
+				if !slices.Equal(providers.bootstrapToken.deleted, []string{"default"}) {
+					t.Errorf("bootstrap tokens deleted for %v, want [default]", providers.bootstrapToken.deleted)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("getting nodeclass error = %v, want the finalizer to hold the deletion", err)
+			}
+			if result.RequeueAfter != time.Minute {
+				t.Errorf("Reconcile() requeue after = %v, want %v", result.RequeueAfter, time.Minute)
+			}
+			if len(providers.bootstrapToken.deleted) != 0 {
+				t.Errorf("bootstrap tokens deleted for %v, want none", providers.bootstrapToken.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeclass
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/karpenter/pkg/events"
+	"sigs.k8s.io/karpenter/pkg/utils/pretty"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+)
+
+// WaitingOnNodeClaimTerminationEvent is published while a deleted NodeClass waits for its NodeClaims to terminate
+func WaitingOnNodeClaimTerminationEvent(nodeClass *v1.CloudStackNodeClass, names []string) events.Event {
+	return events.Event{
+		InvolvedObject: nodeClass,
+		Type:           corev1.EventTypeNormal,
+		Reason:         "WaitingOnNodeClaimTermination",
+		Message:        fmt.Sprintf("Waiting on NodeClaim termination for %s", pretty.Slice(names, 5)),
+		DedupeValues:   []string{string(nodeClass.UID)},
+	}
+}
+
+// WaitingOnNodePoolDeletionEvent is published while a deleted NodeClass is still referenced by NodePools
+func WaitingOnNodePoolDeletionEvent(nodeClass *v1.CloudStackNodeClass, names []string) events.Event {
+	return events.Event{
+		InvolvedObject: nodeClass,
+		Type:           corev1.EventTypeNormal,
+		Reason:         "WaitingOnNodePoolDeletion",
+		Message:        fmt.Sprintf("Waiting on the deletion of NodePools %s referencing the NodeClass", pretty.Slice(names, 5)),
+		DedupeValues:   []string{string(nodeClass.UID)},
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeclass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	nodeclaimutils "sigs.k8s.io/karpenter/pkg/utils/nodeclaim"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+)
+
+// reconcileHash annotates the NodeClass with its hash and the version of the hashing. When the hash
+// version was bumped, the NodeClaims of the NodeClass are re-stamped with the new hash first, so that
+// changing the hashed fields in a release doesn't drift every node.
+func (c *Controller) reconcileHash(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	stored := nodeClass.DeepCopy()
+
+	if nodeClass.Annotations[v1.AnnotationNodeClassHashVersion] != v1.CloudStackNodeClassHashVersion {
+		if err := c.updateNodeClaimHash(ctx, nodeClass); err != nil {
+			return err
+		}
+	}
+	nodeClass.Annotations = lo.Assign(nodeClass.Annotations, map[string]string{
+		v1.AnnotationNodeClassHash:        nodeClass.Hash(),
+		v1.AnnotationNodeClassHashVersion: v1.CloudStackNodeClassHashVersion,
+	})
+
+	if !equality.Semantic.DeepEqual(stored, nodeClass) {
+		if err := c.kubeClient.Patch(ctx, nodeClass, client.MergeFrom(stored)); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+	}
+	return nil
+}
+
+// updateNodeClaimHash re-stamps the hash of the NodeClaims launched with a previous hash version. The
+// hashes of different versions can't be compared, so the NodeClaims take the current NodeClass hash,
+// except the ones already drifted which stay drifted.
+func (c *Controller) updateNodeClaimHash(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	nodeClaims := &karpv1.NodeClaimList{}
+	if err := c.kubeClient.List(ctx, nodeClaims, nodeclaimutils.ForNodeClass(nodeClass)); err != nil {
+		return fmt.Errorf("listing nodeclaims: %w", err)
+	}
+
+	var errs error
+	for i := range nodeClaims.Items {
+		nodeClaim := &nodeClaims.Items[i]
+		if nodeClaim.Annotations[v1.AnnotationNodeClassHashVersion] == v1.CloudStackNodeClassHashVersion {
+			continue
+		}
+		stored := nodeClaim.DeepCopy()
+		nodeClaim.Annotations = lo.Assign(nodeClaim.Annotations, map[string]string{
+			v1.AnnotationNodeClassHashVersion: v1.CloudStackNodeClassHashVersion,
+		})
+		if nodeClaim.StatusConditions().Get(karpv1.ConditionTypeDrifted) == nil {
+			nodeClaim.Annotations[v1.AnnotationNodeClassHash] = nodeClass.Hash()
+		}
+		if err := c.kubeClient.Patch(ctx, nodeClaim, client.MergeFrom(stored)); client.IgnoreNotFound(err) != nil {
+			errs = errors.Join(errs, fmt.Errorf("updating hash of nodeclaim %s: %w", nodeClaim.Name, err))
+			continue
+		}
+		log.FromContext(ctx).V(1).Info("Updated nodeclaim hash", "nodeclaim", nodeClaim.Name, "hashVersion", v1.CloudStackNodeClassHashVersion)
+	}
+	return errs
+}
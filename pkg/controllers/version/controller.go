@@ -0,0 +1,61 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/operatorpkg/reconciler"
+	"github.com/awslabs/operatorpkg/singleton"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/version"
+)
+
+const (
+	controllerName = "version"
+)
+
+// Controller refreshes the Kubernetes version of the API server, which templates selected by
+// Kubernetes version are matched against
+type Controller struct {
+	versionProvider version.Provider
+}
+
+// NewController creates a new version controller
+func NewController(versionProvider version.Provider) *Controller {
+	return &Controller{
+		versionProvider: versionProvider,
+	}
+}
+
+// Reconcile refreshes the Kubernetes version
+func (c *Controller) Reconcile(ctx context.Context) (reconciler.Result, error) {
+	if err := c.versionProvider.UpdateVersion(ctx); err != nil {
+		return reconciler.Result{}, fmt.Errorf("updating kubernetes version: %w", err)
+	}
+	return reconciler.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// Register registers the controller with the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.NewControllerManagedBy(m).
+		Named(controllerName).
+		WatchesRawSource(singleton.Source()).
+		Complete(singleton.AsReconciler(c))
+}
@@ -25,6 +25,7 @@ type CloudStackAPI struct {
 	DeployVirtualMachineFunc  func(*cloudstack.DeployVirtualMachineParams) (*cloudstack.DeployVirtualMachineResponse, error)
 	ListVirtualMachinesFunc   func(*cloudstack.ListVirtualMachinesParams) (*cloudstack.ListVirtualMachinesResponse, error)
 	DestroyVirtualMachineFunc func(*cloudstack.DestroyVirtualMachineParams) (*cloudstack.DestroyVirtualMachineResponse, error)
+	UpdateVirtualMachineFunc  func(*cloudstack.UpdateVirtualMachineParams) (*cloudstack.UpdateVirtualMachineResponse, error)
 
 	// ServiceOffering responses
 	ListServiceOfferingsFunc func(*cloudstack.ListServiceOfferingsParams) (*cloudstack.ListServiceOfferingsResponse, error)
@@ -38,9 +39,28 @@ type CloudStackAPI struct {
 	// Zone responses
 	ListZonesFunc func(*cloudstack.ListZonesParams) (*cloudstack.ListZonesResponse, error)
 
+	// Hypervisor responses
+	ListHypervisorsFunc func(*cloudstack.ListHypervisorsParams) (*cloudstack.ListHypervisorsResponse, error)
+
+	// DiskOffering responses
+	ListDiskOfferingsFunc func(*cloudstack.ListDiskOfferingsParams) (*cloudstack.ListDiskOfferingsResponse, error)
+
+	// Volume responses
+	CreateVolumeFunc func(*cloudstack.CreateVolumeParams) (*cloudstack.CreateVolumeResponse, error)
+	AttachVolumeFunc func(*cloudstack.AttachVolumeParams) (*cloudstack.AttachVolumeResponse, error)
+	DeleteVolumeFunc func(*cloudstack.DeleteVolumeParams) (*cloudstack.DeleteVolumeResponse, error)
+	ListVolumesFunc  func(*cloudstack.ListVolumesParams) (*cloudstack.ListVolumesResponse, error)
+
+	// SSH key pair responses
+	ListSSHKeyPairsFunc func(*cloudstack.ListSSHKeyPairsParams) (*cloudstack.ListSSHKeyPairsResponse, error)
+
 	// Tag responses
 	CreateTagsFunc func(*cloudstack.CreateTagsParams) (*cloudstack.CreateTagsResponse, error)
+	DeleteTagsFunc func(*cloudstack.DeleteTagsParams) (*cloudstack.DeleteTagsResponse, error)
 	ListTagsFunc   func(*cloudstack.ListTagsParams) (*cloudstack.ListTagsResponse, error)
+
+	// Event responses
+	ListEventsFunc func(*cloudstack.ListEventsParams) (*cloudstack.ListEventsResponse, error)
 }
 
 var _ csapi.CloudStackAPI = (*CloudStackAPI)(nil)
@@ -70,6 +90,13 @@ func (f *CloudStackAPI) DestroyVirtualMachine(p *cloudstack.DestroyVirtualMachin
 	return &cloudstack.DestroyVirtualMachineResponse{}, nil
 }
 
+func (f *CloudStackAPI) UpdateVirtualMachine(p *cloudstack.UpdateVirtualMachineParams) (*cloudstack.UpdateVirtualMachineResponse, error) {
+	if f.UpdateVirtualMachineFunc != nil {
+		return f.UpdateVirtualMachineFunc(p)
+	}
+	return &cloudstack.UpdateVirtualMachineResponse{}, nil
+}
+
 func (f *CloudStackAPI) ListServiceOfferings(p *cloudstack.ListServiceOfferingsParams) (*cloudstack.ListServiceOfferingsResponse, error) {
 	if f.ListServiceOfferingsFunc != nil {
 		return f.ListServiceOfferingsFunc(p)
@@ -114,7 +141,17 @@ func (f *CloudStackAPI) GetZoneID(name string, opts ...cloudstack.OptionFunc) (s
 	return "zone-123", 1, nil
 }
 
+func (f *CloudStackAPI) ListHypervisors(p *cloudstack.ListHypervisorsParams) (*cloudstack.ListHypervisorsResponse, error) {
+	if f.ListHypervisorsFunc != nil {
+		return f.ListHypervisorsFunc(p)
+	}
+	return &cloudstack.ListHypervisorsResponse{}, nil
+}
+
 func (f *CloudStackAPI) ListDiskOfferings(p *cloudstack.ListDiskOfferingsParams) (*cloudstack.ListDiskOfferingsResponse, error) {
+	if f.ListDiskOfferingsFunc != nil {
+		return f.ListDiskOfferingsFunc(p)
+	}
 	return &cloudstack.ListDiskOfferingsResponse{}, nil
 }
 
@@ -122,6 +159,41 @@ func (f *CloudStackAPI) GetDiskOfferingID(name string, opts ...cloudstack.Option
 	return "disk-offering-123", 1, nil
 }
 
+func (f *CloudStackAPI) CreateVolume(p *cloudstack.CreateVolumeParams) (*cloudstack.CreateVolumeResponse, error) {
+	if f.CreateVolumeFunc != nil {
+		return f.CreateVolumeFunc(p)
+	}
+	return &cloudstack.CreateVolumeResponse{}, nil
+}
+
+func (f *CloudStackAPI) AttachVolume(p *cloudstack.AttachVolumeParams) (*cloudstack.AttachVolumeResponse, error) {
+	if f.AttachVolumeFunc != nil {
+		return f.AttachVolumeFunc(p)
+	}
+	return &cloudstack.AttachVolumeResponse{}, nil
+}
+
+func (f *CloudStackAPI) DeleteVolume(p *cloudstack.DeleteVolumeParams) (*cloudstack.DeleteVolumeResponse, error) {
+	if f.DeleteVolumeFunc != nil {
+		return f.DeleteVolumeFunc(p)
+	}
+	return &cloudstack.DeleteVolumeResponse{}, nil
+}
+
+func (f *CloudStackAPI) ListVolumes(p *cloudstack.ListVolumesParams) (*cloudstack.ListVolumesResponse, error) {
+	if f.ListVolumesFunc != nil {
+		return f.ListVolumesFunc(p)
+	}
+	return &cloudstack.ListVolumesResponse{}, nil
+}
+
+func (f *CloudStackAPI) ListSSHKeyPairs(p *cloudstack.ListSSHKeyPairsParams) (*cloudstack.ListSSHKeyPairsResponse, error) {
+	if f.ListSSHKeyPairsFunc != nil {
+		return f.ListSSHKeyPairsFunc(p)
+	}
+	return &cloudstack.ListSSHKeyPairsResponse{}, nil
+}
+
 func (f *CloudStackAPI) CreateTags(p *cloudstack.CreateTagsParams) (*cloudstack.CreateTagsResponse, error) {
 	if f.CreateTagsFunc != nil {
 		return f.CreateTagsFunc(p)
@@ -130,6 +202,9 @@ func (f *CloudStackAPI) CreateTags(p *cloudstack.CreateTagsParams) (*cloudstack.
 }
 
 func (f *CloudStackAPI) DeleteTags(p *cloudstack.DeleteTagsParams) (*cloudstack.DeleteTagsResponse, error) {
+	if f.DeleteTagsFunc != nil {
+		return f.DeleteTagsFunc(p)
+	}
 	return &cloudstack.DeleteTagsResponse{}, nil
 }
 
@@ -139,3 +214,10 @@ func (f *CloudStackAPI) ListTags(p *cloudstack.ListTagsParams) (*cloudstack.List
 	}
 	return &cloudstack.ListTagsResponse{}, nil
 }
+
+func (f *CloudStackAPI) ListEvents(p *cloudstack.ListEventsParams) (*cloudstack.ListEventsResponse, error) {
+	if f.ListEventsFunc != nil {
+		return f.ListEventsFunc(p)
+	}
+	return &cloudstack.ListEventsResponse{}, nil
+}
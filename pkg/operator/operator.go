@@ -19,15 +19,23 @@ import (
 	"time"
 
 	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+	"k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/karpenter/pkg/operator"
 
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/apis"
 	csapi "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudstack"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/bootstraptoken"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instance"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/instancetype"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/network"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/sshkeypair"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/userdata"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/version"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
 )
 
@@ -36,16 +44,26 @@ const (
 	defaultCleanupInterval = 30 * time.Minute
 )
 
+func init() {
+	// Register the CloudStackNodeClass with the scheme of the manager client and webhooks
+	lo.Must0(apis.AddToScheme(scheme.Scheme))
+}
+
 // Operator is the CloudStack-specific operator
 type Operator struct {
 	*operator.Operator
 
-	CloudStackClient     csapi.CloudStackAPI
-	ZoneProvider         zone.Provider
-	NetworkProvider      network.Provider
-	TemplateProvider     template.Provider
-	InstanceTypeProvider instancetype.Provider
-	InstanceProvider     instance.Provider
+	CloudStackClient       csapi.CloudStackAPI
+	VersionProvider        version.Provider
+	ZoneProvider           zone.Provider
+	NetworkProvider        network.Provider
+	TemplateProvider       template.Provider
+	DiskOfferingProvider   diskoffering.Provider
+	SSHKeyPairProvider     sshkeypair.Provider
+	BootstrapTokenProvider bootstraptoken.Provider
+	UserDataProvider       userdata.Provider
+	InstanceTypeProvider   instancetype.Provider
+	InstanceProvider       instance.Provider
 }
 
 // NewOperator creates a new CloudStack operator
@@ -60,11 +78,12 @@ func NewOperator(ctx context.Context, operator *operator.Operator) (context.Cont
 
 	// Create CloudStack client
 	csClient, err := csapi.NewClient(ctx, csapi.Config{
-		APIURL:    opts.CloudStackAPIURL,
-		APIKey:    opts.CloudStackAPIKey,
-		SecretKey: opts.CloudStackSecretKey,
-		VerifySSL: opts.CloudStackVerifySSL,
-		Timeout:   60 * time.Second,
+		APIURL:      opts.CloudStackAPIURL,
+		APIKey:      opts.CloudStackAPIKey,
+		SecretKey:   opts.CloudStackSecretKey,
+		VerifySSL:   opts.CloudStackVerifySSL,
+		Timeout:     60 * time.Second,
+		HTTPGETOnly: opts.CloudStackHTTPGETOnly,
 	})
 	if err != nil {
 		log.FromContext(ctx).Error(err, "Failed to create CloudStack client")
@@ -72,21 +91,33 @@ func NewOperator(ctx context.Context, operator *operator.Operator) (context.Cont
 	}
 
 	// Create caches
+	versionCache := cache.New(defaultCacheTTL, defaultCleanupInterval)
 	zoneCache := cache.New(defaultCacheTTL, defaultCleanupInterval)
 	networkCache := cache.New(defaultCacheTTL, defaultCleanupInterval)
 	templateCache := cache.New(defaultCacheTTL, defaultCleanupInterval)
+	diskOfferingCache := cache.New(defaultCacheTTL, defaultCleanupInterval)
+	sshKeyPairCache := cache.New(defaultCacheTTL, defaultCleanupInterval)
+	bootstrapTokenCache := cache.New(bootstraptoken.DefaultTTL, defaultCleanupInterval)
 	instanceTypeCache := cache.New(defaultCacheTTL, defaultCleanupInterval)
 	instanceCache := cache.New(defaultCacheTTL, defaultCleanupInterval)
 
 	// Create providers
+	versionProvider := version.NewDefaultProvider(operator.KubernetesInterface, versionCache)
 	zoneProvider := zone.NewDefaultProvider(csClient, zoneCache)
 	networkProvider := network.NewDefaultProvider(csClient, networkCache)
-	templateProvider := template.NewDefaultProvider(csClient, templateCache)
-	instanceTypeProvider := instancetype.NewDefaultProvider(csClient, instanceTypeCache)
+	templateProvider := template.NewDefaultProvider(csClient, zoneProvider, versionProvider, templateCache)
+	diskOfferingProvider := diskoffering.NewDefaultProvider(csClient, diskOfferingCache)
+	sshKeyPairProvider := sshkeypair.NewDefaultProvider(csClient, sshKeyPairCache)
+	bootstrapTokenProvider := bootstraptoken.NewDefaultProvider(operator.GetClient(), operator.GetAPIReader(), bootstrapTokenCache)
+	userDataProvider := userdata.NewDefaultProvider(operator.GetAPIReader(), bootstrapTokenProvider)
+	instanceTypeProvider := instancetype.NewDefaultProvider(csClient, templateProvider, diskOfferingProvider, instanceTypeCache)
 	instanceProvider := instance.NewDefaultProvider(
 		csClient,
 		networkProvider,
 		templateProvider,
+		diskOfferingProvider,
+		userDataProvider,
+		bootstrapTokenProvider,
 		instanceCache,
 		opts.ClusterName,
 	)
@@ -94,12 +125,17 @@ func NewOperator(ctx context.Context, operator *operator.Operator) (context.Cont
 	log.FromContext(ctx).Info("CloudStack operator initialized successfully")
 
 	return ctx, &Operator{
-		Operator:             operator,
-		CloudStackClient:     csClient,
-		ZoneProvider:         zoneProvider,
-		NetworkProvider:      networkProvider,
-		TemplateProvider:     templateProvider,
-		InstanceTypeProvider: instanceTypeProvider,
-		InstanceProvider:     instanceProvider,
+		Operator:               operator,
+		CloudStackClient:       csClient,
+		VersionProvider:        versionProvider,
+		ZoneProvider:           zoneProvider,
+		NetworkProvider:        networkProvider,
+		TemplateProvider:       templateProvider,
+		DiskOfferingProvider:   diskOfferingProvider,
+		SSHKeyPairProvider:     sshKeyPairProvider,
+		BootstrapTokenProvider: bootstrapTokenProvider,
+		UserDataProvider:       userDataProvider,
+		InstanceTypeProvider:   instanceTypeProvider,
+		InstanceProvider:       instanceProvider,
 	}
 }
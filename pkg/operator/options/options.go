@@ -16,9 +16,28 @@ package options
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	defaultEphemeralStorageEvictionThreshold = "10%"
+	defaultEphemeralStorageSystemReserved    = "1Gi"
+	defaultUserDataMaxLength                 = 32768
+	defaultWebhookPort                       = 8443
+
+	// ValidationModeDeny rejects invalid NodeClasses at admission
+	ValidationModeDeny = "Deny"
+	// ValidationModeWarn admits invalid NodeClasses with warnings
+	ValidationModeWarn = "Warn"
 )
 
 type Options struct {
@@ -26,7 +45,37 @@ type Options struct {
 	CloudStackAPIKey    string
 	CloudStackSecretKey string
 	CloudStackVerifySSL bool
-	ClusterName         string
+	// CloudStackHTTPGETOnly restricts the CloudStack client to HTTP GET
+	CloudStackHTTPGETOnly bool
+	ClusterName           string
+
+	// ClusterEndpoint is the URL nodes join when their user data is generated from a bootstrap mode
+	ClusterEndpoint string
+	// ClusterCABundle is the base64-encoded PEM CA bundle of the cluster, used to verify the cluster endpoint
+	ClusterCABundle string
+
+	// EphemeralStorageEvictionThreshold is the hard eviction threshold for node ephemeral storage,
+	// either as a percentage of the disk (e.g. "10%") or as a quantity (e.g. "2Gi")
+	EphemeralStorageEvictionThreshold string
+	// EphemeralStorageSystemReserved is the ephemeral storage reserved for the OS system daemons
+	EphemeralStorageSystemReserved resource.Quantity
+
+	// UserDataMaxLength is the maximum length of base64-encoded user data, as configured
+	// by the vm.userdata.max.length CloudStack global setting
+	UserDataMaxLength int
+
+	// WebhookEnabled serves the NodeClass validating admission webhook
+	WebhookEnabled bool
+	// WebhookPort is the port the admission webhooks are served on
+	WebhookPort int
+	// NodeClassValidationMode is whether the validating webhook denies invalid NodeClasses or only
+	// warns about them, either Deny or Warn
+	NodeClassValidationMode string
+
+	// NodeLabelTags are the keys of the node labels set as tags on the VMs of registered nodes
+	NodeLabelTags []string
+	// DisplayNameFromNode sets the display name of the VMs of registered nodes to the node name
+	DisplayNameFromNode bool
 }
 
 func (o *Options) AddFlags(fs interface{}) {
@@ -53,15 +102,89 @@ func (o *Options) Parse(ctx context.Context, _ ...interface{}) error {
 	}
 
 	o.CloudStackVerifySSL = os.Getenv("CLOUDSTACK_VERIFY_SSL") != "false"
+	o.CloudStackHTTPGETOnly = os.Getenv("CLOUDSTACK_HTTP_GET_ONLY") == "true"
 
 	o.ClusterName = os.Getenv("CLUSTER_NAME")
 	if o.ClusterName == "" {
 		errs = errors.Join(errs, fmt.Errorf("CLUSTER_NAME is required"))
 	}
 
+	o.ClusterEndpoint = os.Getenv("CLUSTER_ENDPOINT")
+	if o.ClusterEndpoint != "" {
+		if u, err := url.Parse(o.ClusterEndpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = errors.Join(errs, fmt.Errorf("CLUSTER_ENDPOINT must be an https URL, got %q", o.ClusterEndpoint))
+		}
+	}
+
+	o.ClusterCABundle = os.Getenv("CLUSTER_CA_BUNDLE")
+	if o.ClusterCABundle != "" {
+		if _, err := base64.StdEncoding.DecodeString(o.ClusterCABundle); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("CLUSTER_CA_BUNDLE must be base64-encoded: %w", err))
+		}
+	}
+
+	o.EphemeralStorageEvictionThreshold = envOrDefault("EPHEMERAL_STORAGE_EVICTION_THRESHOLD", defaultEphemeralStorageEvictionThreshold)
+	if err := ValidateThreshold(o.EphemeralStorageEvictionThreshold); err != nil {
+		errs = errors.Join(errs, fmt.Errorf("EPHEMERAL_STORAGE_EVICTION_THRESHOLD: %w", err))
+	}
+
+	systemReserved, err := resource.ParseQuantity(envOrDefault("EPHEMERAL_STORAGE_SYSTEM_RESERVED", defaultEphemeralStorageSystemReserved))
+	if err != nil {
+		errs = errors.Join(errs, fmt.Errorf("EPHEMERAL_STORAGE_SYSTEM_RESERVED: %w", err))
+	}
+	o.EphemeralStorageSystemReserved = systemReserved
+
+	userDataMaxLength, err := strconv.Atoi(envOrDefault("USER_DATA_MAX_LENGTH", strconv.Itoa(defaultUserDataMaxLength)))
+	if err != nil || userDataMaxLength <= 0 {
+		errs = errors.Join(errs, fmt.Errorf("USER_DATA_MAX_LENGTH must be a positive integer"))
+	}
+	o.UserDataMaxLength = userDataMaxLength
+
+	o.WebhookEnabled = os.Getenv("WEBHOOK_ENABLED") == "true"
+	webhookPort, err := strconv.Atoi(envOrDefault("WEBHOOK_PORT", strconv.Itoa(defaultWebhookPort)))
+	if err != nil || webhookPort <= 0 {
+		errs = errors.Join(errs, fmt.Errorf("WEBHOOK_PORT must be a positive integer"))
+	}
+	o.WebhookPort = webhookPort
+
+	o.NodeClassValidationMode = envOrDefault("NODECLASS_VALIDATION_MODE", ValidationModeDeny)
+	if o.NodeClassValidationMode != ValidationModeDeny && o.NodeClassValidationMode != ValidationModeWarn {
+		errs = errors.Join(errs, fmt.Errorf("NODECLASS_VALIDATION_MODE must be %s or %s, got %q", ValidationModeDeny, ValidationModeWarn, o.NodeClassValidationMode))
+	}
+
+	o.NodeLabelTags = lo.Compact(lo.Map(strings.Split(os.Getenv("NODE_LABEL_TAGS"), ","), func(key string, _ int) string {
+		return strings.TrimSpace(key)
+	}))
+	o.DisplayNameFromNode = os.Getenv("VM_DISPLAY_NAME_FROM_NODE") == "true"
+
 	return errs
 }
 
+// ValidateThreshold checks that a threshold is either a percentage or a resource quantity
+func ValidateThreshold(threshold string) error {
+	if percentage, ok := strings.CutSuffix(threshold, "%"); ok {
+		value, err := strconv.ParseFloat(percentage, 64)
+		if err != nil {
+			return fmt.Errorf("invalid percentage %q: %w", threshold, err)
+		}
+		if value < 0 || value > 100 {
+			return fmt.Errorf("percentage %q must be between 0%% and 100%%", threshold)
+		}
+		return nil
+	}
+	if _, err := resource.ParseQuantity(threshold); err != nil {
+		return fmt.Errorf("invalid quantity %q: %w", threshold, err)
+	}
+	return nil
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
 type optionsKey struct{}
 
 func ToContext(ctx context.Context, opts *Options) context.Context {
@@ -73,7 +196,12 @@ func FromContext(ctx context.Context) *Options {
 	if data == nil {
 		// Return default options if not found
 		return &Options{
-			CloudStackVerifySSL: true,
+			CloudStackVerifySSL:               true,
+			EphemeralStorageEvictionThreshold: defaultEphemeralStorageEvictionThreshold,
+			EphemeralStorageSystemReserved:    resource.MustParse(defaultEphemeralStorageSystemReserved),
+			UserDataMaxLength:                 defaultUserDataMaxLength,
+			WebhookPort:                       defaultWebhookPort,
+			NodeClassValidationMode:           ValidationModeDeny,
 		}
 	}
 	return data.(*Options)
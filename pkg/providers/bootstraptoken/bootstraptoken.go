@@ -0,0 +1,285 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstraptoken
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+)
+
+const (
+	// Namespace is the namespace bootstrap token Secrets live in
+	Namespace = "kube-system"
+
+	// DefaultTTL is the lifetime of minted tokens when the NodeClass doesn't set one
+	DefaultTTL = time.Hour
+
+	// authExtraGroups is the group kubeadm grants node bootstrap and CSR auto-approval to
+	authExtraGroups = "system:bootstrappers:kubeadm:default-node-token"
+
+	tokenCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// Provider provides the tokens nodes use to join the cluster
+type Provider interface {
+	Token(ctx context.Context, nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim) (string, error)
+	DeleteExpired(ctx context.Context) error
+	DeleteForNodeClass(ctx context.Context, nodeClassName string) error
+	DeleteForNodeClaim(ctx context.Context, nodeClaimName string) error
+}
+
+// DefaultProvider implements the BootstrapToken Provider
+type DefaultProvider struct {
+	kubeClient client.Client
+	apiReader  client.Reader
+	cache      *cache.Cache
+	mu         sync.Mutex
+}
+
+// NewDefaultProvider creates a new bootstrap token provider. Secrets are read through the
+// API reader so that the manager doesn't start a cluster-wide Secret informer.
+func NewDefaultProvider(kubeClient client.Client, apiReader client.Reader, cache *cache.Cache) *DefaultProvider {
+	return &DefaultProvider{
+		kubeClient: kubeClient,
+		apiReader:  apiReader,
+		cache:      cache,
+	}
+}
+
+// Token returns the token the node launched for a NodeClaim joins with. This is the NodeClass static
+// token, or a minted bootstrap token when the NodeClass sets a token scope. An empty token is
+// returned when the NodeClass sets neither.
+func (p *DefaultProvider) Token(ctx context.Context, nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim) (string, error) {
+	bootstrap := nodeClass.Spec.Bootstrap
+	if bootstrap == nil {
+		return "", nil
+	}
+	if bootstrap.TokenScope == nil {
+		return lo.FromPtr(bootstrap.Token), nil
+	}
+
+	ttl := DefaultTTL
+	if bootstrap.TokenTTL != nil {
+		ttl = bootstrap.TokenTTL.Duration
+	}
+	nodePool := nodeClaim.Labels[karpv1.NodePoolLabelKey]
+
+	if *bootstrap.TokenScope == v1.TokenScopeLaunch {
+		return p.create(ctx, ttl, map[string]string{
+			v1.NodeClassTagKey: nodeClass.Name,
+			v1.NodePoolTagKey:  nodePool,
+			v1.NodeClaimTagKey: nodeClaim.Name,
+		})
+	}
+	return p.nodePoolToken(ctx, nodeClass, nodePool, ttl)
+}
+
+// nodePoolToken returns the token shared by the nodes of a NodePool. The token is rotated once
+// half of its TTL has passed, so that it stays valid while the nodes launched with it join.
+func (p *DefaultProvider) nodePoolToken(ctx context.Context, nodeClass *v1.CloudStackNodeClass, nodePool string, ttl time.Duration) (string, error) {
+	cacheKey := fmt.Sprintf("bootstrap-token-%s-%s", nodeClass.Name, nodePool)
+
+	// Check cache first
+	if cached, found := p.cache.Get(cacheKey); found {
+		return cached.(string), nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Double-check after acquiring lock
+	if cached, found := p.cache.Get(cacheKey); found {
+		return cached.(string), nil
+	}
+
+	labels := map[string]string{
+		v1.NodeClassTagKey: nodeClass.Name,
+		v1.NodePoolTagKey:  nodePool,
+	}
+
+	// Reuse the token with the longest remaining lifetime if it's not due for rotation
+	secrets, err := p.list(ctx, labels)
+	if err != nil {
+		return "", err
+	}
+	var token string
+	var expiration time.Time
+	for _, secret := range secrets {
+		if e, ok := expirationOf(&secret); ok && e.After(expiration) {
+			token = string(secret.Data["token-id"]) + "." + string(secret.Data["token-secret"])
+			expiration = e
+		}
+	}
+	if time.Until(expiration) <= ttl/2 {
+		if token, err = p.create(ctx, ttl, labels); err != nil {
+			return "", err
+		}
+		expiration = time.Now().Add(ttl)
+	}
+
+	// go-cache keeps entries with a non-positive duration forever, so a token already due for
+	// rotation isn't cached
+	if d := time.Until(expiration) - ttl/2; d > 0 {
+		p.cache.Set(cacheKey, token, d)
+	}
+
+	return token, nil
+}
+
+// create creates a bootstrap token Secret expiring after the given TTL
+func (p *DefaultProvider) create(ctx context.Context, ttl time.Duration, labels map[string]string) (string, error) {
+	tokenID, err := randomString(6)
+	if err != nil {
+		return "", fmt.Errorf("generating token ID: %w", err)
+	}
+	tokenSecret, err := randomString(16)
+	if err != nil {
+		return "", fmt.Errorf("generating token secret: %w", err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-token-" + tokenID,
+			Namespace: Namespace,
+			Labels:    lo.Assign(labels, map[string]string{v1.ManagedByTagKey: "karpenter"}),
+		},
+		Type: corev1.SecretTypeBootstrapToken,
+		StringData: map[string]string{
+			"description":                    "Bootstrap token minted by Karpenter",
+			"token-id":                       tokenID,
+			"token-secret":                   tokenSecret,
+			"expiration":                     time.Now().Add(ttl).UTC().Format(time.RFC3339),
+			"usage-bootstrap-authentication": "true",
+			"usage-bootstrap-signing":        "true",
+			"auth-extra-groups":              authExtraGroups,
+		},
+	}
+	if err := p.kubeClient.Create(ctx, secret); err != nil {
+		return "", fmt.Errorf("creating bootstrap token secret: %w", err)
+	}
+
+	log.FromContext(ctx).Info("Created bootstrap token", "secret", secret.Name, "ttl", ttl)
+
+	return tokenID + "." + tokenSecret, nil
+}
+
+// DeleteExpired deletes the expired bootstrap token Secrets minted by Karpenter
+func (p *DefaultProvider) DeleteExpired(ctx context.Context) error {
+	secrets, err := p.list(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for i := range secrets {
+		expiration, ok := expirationOf(&secrets[i])
+		if ok && expiration.After(time.Now()) {
+			continue
+		}
+		if err := p.kubeClient.Delete(ctx, &secrets[i]); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("deleting bootstrap token secret %s: %w", secrets[i].Name, err)
+		}
+		log.FromContext(ctx).Info("Deleted expired bootstrap token", "secret", secrets[i].Name)
+	}
+	return nil
+}
+
+// DeleteForNodeClass deletes the bootstrap token Secrets minted for a NodeClass, once the NodeClass
+// is deleted and no node can join with them anymore
+func (p *DefaultProvider) DeleteForNodeClass(ctx context.Context, nodeClassName string) error {
+	secrets, err := p.list(ctx, map[string]string{v1.NodeClassTagKey: nodeClassName})
+	if err != nil {
+		return err
+	}
+	for i := range secrets {
+		if err := p.kubeClient.Delete(ctx, &secrets[i]); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("deleting bootstrap token secret %s: %w", secrets[i].Name, err)
+		}
+		log.FromContext(ctx).Info("Deleted bootstrap token", "secret", secrets[i].Name, "nodeclass", nodeClassName)
+	}
+	// Forget the NodePool tokens of the NodeClass, so that a NodeClass recreated with the same name mints
+	// new ones. Keys of NodeClasses sharing the prefix are dropped as well and simply looked up again.
+	for key := range p.cache.Items() {
+		if strings.HasPrefix(key, fmt.Sprintf("bootstrap-token-%s-", nodeClassName)) {
+			p.cache.Delete(key)
+		}
+	}
+	return nil
+}
+
+// DeleteForNodeClaim deletes the launch tokens minted for a NodeClaim, once its launch failed and no
+// node joins with them
+func (p *DefaultProvider) DeleteForNodeClaim(ctx context.Context, nodeClaimName string) error {
+	secrets, err := p.list(ctx, map[string]string{v1.NodeClaimTagKey: nodeClaimName})
+	if err != nil {
+		return err
+	}
+	for i := range secrets {
+		if err := p.kubeClient.Delete(ctx, &secrets[i]); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("deleting bootstrap token secret %s: %w", secrets[i].Name, err)
+		}
+		log.FromContext(ctx).Info("Deleted bootstrap token", "secret", secrets[i].Name, "nodeclaim", nodeClaimName)
+	}
+	return nil
+}
+
+// list returns the bootstrap token Secrets minted by Karpenter matching the given labels
+func (p *DefaultProvider) list(ctx context.Context, labels map[string]string) ([]corev1.Secret, error) {
+	secrets := &corev1.SecretList{}
+	if err := p.apiReader.List(ctx, secrets,
+		client.InNamespace(Namespace),
+		client.MatchingLabels(lo.Assign(labels, map[string]string{v1.ManagedByTagKey: "karpenter"})),
+	); err != nil {
+		return nil, fmt.Errorf("listing bootstrap token secrets: %w", err)
+	}
+	return lo.Filter(secrets.Items, func(s corev1.Secret, _ int) bool {
+		return s.Type == corev1.SecretTypeBootstrapToken
+	}), nil
+}
+
+// expirationOf returns the expiration of a bootstrap token Secret
+func expirationOf(secret *corev1.Secret) (time.Time, bool) {
+	expiration, err := time.Parse(time.RFC3339, string(secret.Data["expiration"]))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiration, true
+}
+
+// randomString returns a random string of the bootstrap token charset, [a-z0-9]
+func randomString(length int) (string, error) {
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(tokenCharset))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = tokenCharset[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,267 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstraptoken
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+)
+
+var tokenPattern = regexp.MustCompile(`^[a-z0-9]{6}\.[a-z0-9]{16}$`)
+
+// tokenSecret returns a bootstrap token Secret minted by Karpenter expiring at the given time
+func tokenSecret(tokenID string, expiration time.Time, labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-token-" + tokenID,
+			Namespace: Namespace,
+			Labels:    lo.Assign(labels, map[string]string{v1.ManagedByTagKey: "karpenter"}),
+		},
+		Type: corev1.SecretTypeBootstrapToken,
+		Data: map[string][]byte{
+			"token-id":     []byte(tokenID),
+			"token-secret": []byte("0123456789abcdef"),
+			"expiration":   []byte(expiration.UTC().Format(time.RFC3339)),
+		},
+	}
+}
+
+func newTestProvider(objects ...client.Object) (*DefaultProvider, client.Client) {
+	kubeClient := fake.NewClientBuilder().WithObjects(objects...).Build()
+	return NewDefaultProvider(kubeClient, kubeClient, cache.New(time.Hour, time.Hour)), kubeClient
+}
+
+func TestToken(t *testing.T) {
+	nodePoolLabels := map[string]string{v1.NodeClassTagKey: "default", v1.NodePoolTagKey: "pool-a"}
+	tests := []struct {
+		name       string
+		bootstrap  *v1.BootstrapConfiguration
+		secrets    []client.Object
+		want       string
+		wantMinted bool
+		wantCount  int
+	}{
+		{
+			name: "no bootstrap configuration",
+		},
+		{
+			name:      "static token",
+			bootstrap: &v1.BootstrapConfiguration{Token: lo.ToPtr("abcdef.0123456789abcdef")},
+			want:      "abcdef.0123456789abcdef",
+		},
+		{
+			name:       "launch token is minted for every launch",
+			bootstrap:  &v1.BootstrapConfiguration{TokenScope: lo.ToPtr(v1.TokenScopeLaunch)},
+			secrets:    []client.Object{tokenSecret("aaaaaa", time.Now().Add(time.Hour), nodePoolLabels)},
+			wantMinted: true,
+			wantCount:  2,
+		},
+		{
+			name:       "NodePool token is minted when none exists",
+			bootstrap:  &v1.BootstrapConfiguration{TokenScope: lo.ToPtr(v1.TokenScopeNodePool)},
+			wantMinted: true,
+			wantCount:  1,
+		},
+		{
+			name:      "NodePool token is reused until half of its TTL has passed",
+			bootstrap: &v1.BootstrapConfiguration{TokenScope: lo.ToPtr(v1.TokenScopeNodePool)},
+			secrets: []client.Object{
+				tokenSecret("aaaaaa", time.Now().Add(40*time.Minute), nodePoolLabels),
+				tokenSecret("bbbbbb", time.Now().Add(50*time.Minute), nodePoolLabels),
+			},
+			want:      "bbbbbb.0123456789abcdef",
+			wantCount: 2,
+		},
+		{
+			name:       "NodePool token is rotated once half of its TTL has passed",
+			bootstrap:  &v1.BootstrapConfiguration{TokenScope: lo.ToPtr(v1.TokenScopeNodePool)},
+			secrets:    []client.Object{tokenSecret("aaaaaa", time.Now().Add(20*time.Minute), nodePoolLabels)},
+			wantMinted: true,
+			wantCount:  2,
+		},
+		{
+			name:      "rotation follows the NodeClass TTL",
+			bootstrap: &v1.BootstrapConfiguration{TokenScope: lo.ToPtr(v1.TokenScopeNodePool), TokenTTL: &metav1.Duration{Duration: 30 * time.Minute}},
+			secrets:   []client.Object{tokenSecret("aaaaaa", time.Now().Add(20*time.Minute), nodePoolLabels)},
+			want:      "aaaaaa.0123456789abcdef",
+			wantCount: 1,
+		},
+		{
+			name:      "tokens of other NodePools aren't reused",
+			bootstrap: &v1.BootstrapConfiguration{TokenScope: lo.ToPtr(v1.TokenScopeNodePool)},
+			secrets: []client.Object{
+				tokenSecret("aaaaaa", time.Now().Add(time.Hour), map[string]string{v1.NodeClassTagKey: "default", v1.NodePoolTagKey: "pool-b"}),
+			},
+			wantMinted: true,
+			wantCount:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, kubeClient := newTestProvider(tt.secrets...)
+			nodeClass := &v1.CloudStackNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: v1.CloudStackNodeClassSpec{Bootstrap: tt.bootstrap}}
+			nodeClaim := &karpv1.NodeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pool-a-abcde", Labels: map[string]string{karpv1.NodePoolLabelKey: "pool-a"}}}
+
+			token, err := p.Token(context.Background(), nodeClass, nodeClaim)
+			if err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+			if tt.wantMinted {
+				if !tokenPattern.MatchString(token) || slices.ContainsFunc(tt.secrets, func(o client.Object) bool { return o.GetName() == "bootstrap-token-"+token[:6] }) {
+					t.Errorf("Token() = %q, want a newly minted token", token)
+				}
+			} else if token != tt.want {
+				t.Errorf("Token() = %q, want %q", token, tt.want)
+			}
+
+			secrets := &corev1.SecretList{}
+			if err := kubeClient.List(context.Background(), secrets, client.InNamespace(Namespace)); err != nil {
+				t.Fatalf("listing secrets: %v", err)
+			}
+			if len(secrets.Items) != tt.wantCount {
+				t.Errorf("got %d bootstrap token secrets, want %d", len(secrets.Items), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestNodePoolTokenIsCached(t *testing.T) {
+	p, kubeClient := newTestProvider()
+	nodeClass := &v1.CloudStackNodeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       v1.CloudStackNodeClassSpec{Bootstrap: &v1.BootstrapConfiguration{TokenScope: lo.ToPtr(v1.TokenScopeNodePool)}},
+	}
+	nodeClaim := &karpv1.NodeClaim{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{karpv1.NodePoolLabelKey: "pool-a"}}}
+
+	first, err := p.Token(context.Background(), nodeClass, nodeClaim)
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	second, err := p.Token(context.Background(), nodeClass, nodeClaim)
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if first != second {
+		t.Errorf("Token() = %q, want the cached token %q", second, first)
+	}
+
+	// Deleting the NodeClass tokens forgets the cached token
+	if err := p.DeleteForNodeClass(context.Background(), "default"); err != nil {
+		t.Fatalf("DeleteForNodeClass() error = %v", err)
+	}
+	secrets := &corev1.SecretList{}
+	if err := kubeClient.List(context.Background(), secrets, client.InNamespace(Namespace)); err != nil {
+		t.Fatalf("listing secrets: %v", err)
+	}
+	if len(secrets.Items) != 0 {
+		t.Errorf("got %d bootstrap token secrets after DeleteForNodeClass(), want 0", len(secrets.Items))
+	}
+	third, err := p.Token(context.Background(), nodeClass, nodeClaim)
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if third == first {
+		t.Errorf("Token() = %q after DeleteForNodeClass(), want a newly minted token", third)
+	}
+}
+
+func TestNodePoolTokenDueForRotationIsNotCached(t *testing.T) {
+	p, _ := newTestProvider()
+	nodeClass := &v1.CloudStackNodeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1.CloudStackNodeClassSpec{Bootstrap: &v1.BootstrapConfiguration{
+			TokenScope: lo.ToPtr(v1.TokenScopeNodePool),
+			TokenTTL:   &metav1.Duration{},
+		}},
+	}
+	nodeClaim := &karpv1.NodeClaim{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{karpv1.NodePoolLabelKey: "pool-a"}}}
+
+	// A token without a lifetime is due for rotation as soon as it's minted
+	if _, err := p.Token(context.Background(), nodeClass, nodeClaim); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if _, found := p.cache.Get("bootstrap-token-default-pool-a"); found {
+		t.Errorf("token due for rotation is cached")
+	}
+}
+
+func TestDeleteForNodeClaim(t *testing.T) {
+	launch := tokenSecret("aaaaaa", time.Now().Add(time.Hour), map[string]string{v1.NodeClassTagKey: "default", v1.NodeClaimTagKey: "pool-a-abcde"})
+	otherLaunch := tokenSecret("bbbbbb", time.Now().Add(time.Hour), map[string]string{v1.NodeClassTagKey: "default", v1.NodeClaimTagKey: "pool-a-fghij"})
+	nodePool := tokenSecret("cccccc", time.Now().Add(time.Hour), map[string]string{v1.NodeClassTagKey: "default", v1.NodePoolTagKey: "pool-a"})
+
+	p, kubeClient := newTestProvider(launch, otherLaunch, nodePool)
+	if err := p.DeleteForNodeClaim(context.Background(), "pool-a-abcde"); err != nil {
+		t.Fatalf("DeleteForNodeClaim() error = %v", err)
+	}
+	secrets := &corev1.SecretList{}
+	if err := kubeClient.List(context.Background(), secrets, client.InNamespace(Namespace)); err != nil {
+		t.Fatalf("listing secrets: %v", err)
+	}
+	got := lo.Map(secrets.Items, func(s corev1.Secret, _ int) string { return s.Name })
+	slices.Sort(got)
+	if want := []string{otherLaunch.Name, nodePool.Name}; !slices.Equal(got, want) {
+		t.Errorf("got bootstrap token secrets %v, want %v", got, want)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	withoutExpiration := tokenSecret("cccccc", time.Time{}, nil)
+	delete(withoutExpiration.Data, "expiration")
+	unmanaged := tokenSecret("dddddd", time.Now().Add(-time.Hour), nil)
+	unmanaged.Labels = nil
+	opaque := tokenSecret("eeeeee", time.Now().Add(-time.Hour), nil)
+	opaque.Type = corev1.SecretTypeOpaque
+
+	tests := []struct {
+		name   string
+		secret *corev1.Secret
+		want   bool
+	}{
+		{name: "valid token", secret: tokenSecret("aaaaaa", time.Now().Add(time.Hour), nil), want: true},
+		{name: "expired token", secret: tokenSecret("bbbbbb", time.Now().Add(-time.Minute), nil)},
+		{name: "token without expiration", secret: withoutExpiration},
+		{name: "token not minted by Karpenter", secret: unmanaged, want: true},
+		{name: "secret of another type", secret: opaque, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, kubeClient := newTestProvider(tt.secret)
+			if err := p.DeleteExpired(context.Background()); err != nil {
+				t.Fatalf("DeleteExpired() error = %v", err)
+			}
+			err := kubeClient.Get(context.Background(), client.ObjectKeyFromObject(tt.secret), &corev1.Secret{})
+			if client.IgnoreNotFound(err) != nil {
+				t.Fatalf("getting secret: %v", err)
+			}
+			if kept := err == nil; kept != tt.want {
+				t.Errorf("secret kept = %v, want %v", kept, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diskoffering
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	csapi "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudstack"
+)
+
+// Provider provides disk offering information
+type Provider interface {
+	List(ctx context.Context, zone string) ([]*DiskOffering, error)
+	Resolve(ctx context.Context, nameOrID string, zone string) (*DiskOffering, error)
+	ResolveRootDiskOffering(ctx context.Context, selector *v1.RootDiskOfferingSelector, zone string) (*DiskOffering, error)
+}
+
+// DiskOffering represents a CloudStack disk offering
+type DiskOffering struct {
+	ID               string
+	Name             string
+	DisplayText      string
+	DiskSize         int64 // in GB, 0 for customized offerings
+	IsCustomized     bool
+	IsCustomizedIOPS bool
+	MinIOPS          int64
+	MaxIOPS          int64
+	StorageType      string
+	ProvisioningType string
+	StorageTags      string
+	State            string
+}
+
+// DefaultProvider implements the DiskOffering Provider
+type DefaultProvider struct {
+	csClient csapi.CloudStackAPI
+	cache    *cache.Cache
+	mu       sync.RWMutex
+}
+
+// NewDefaultProvider creates a new disk offering provider
+func NewDefaultProvider(csClient csapi.CloudStackAPI, cache *cache.Cache) *DefaultProvider {
+	return &DefaultProvider{
+		csClient: csClient,
+		cache:    cache,
+	}
+}
+
+// List returns all disk offerings available in a zone
+func (p *DefaultProvider) List(ctx context.Context, zone string) ([]*DiskOffering, error) {
+	cacheKey := fmt.Sprintf("disk-offerings-%s", zone)
+
+	// Check cache first
+	if cached, found := p.cache.Get(cacheKey); found {
+		return cached.([]*DiskOffering), nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Double-check after acquiring lock
+	if cached, found := p.cache.Get(cacheKey); found {
+		return cached.([]*DiskOffering), nil
+	}
+
+	// Get zone ID
+	zoneID, _, err := p.csClient.(*csapi.Client).Zone.GetZoneID(zone)
+	if err != nil {
+		return nil, fmt.Errorf("getting zone ID for %s: %w", zone, err)
+	}
+
+	// Fetch disk offerings from CloudStack
+	params := p.csClient.(*csapi.Client).DiskOffering.NewListDiskOfferingsParams()
+	params.SetZoneid(zoneID)
+
+	resp, err := p.csClient.ListDiskOfferings(params)
+	if err != nil {
+		return nil, fmt.Errorf("listing disk offerings in zone %s: %w", zone, err)
+	}
+
+	offerings := make([]*DiskOffering, 0, len(resp.DiskOfferings))
+	for _, csOffering := range resp.DiskOfferings {
+		offering := &DiskOffering{
+			ID:               csOffering.Id,
+			Name:             csOffering.Name,
+			DisplayText:      csOffering.Displaytext,
+			DiskSize:         csOffering.Disksize,
+			IsCustomized:     csOffering.Iscustomized,
+			IsCustomizedIOPS: csOffering.Iscustomizediops,
+			MinIOPS:          csOffering.Miniops,
+			MaxIOPS:          csOffering.Maxiops,
+			StorageType:      csOffering.Storagetype,
+			ProvisioningType: csOffering.Provisioningtype,
+			StorageTags:      csOffering.Tags,
+			State:            csOffering.State,
+		}
+		offerings = append(offerings, offering)
+	}
+
+	// Cache the results
+	p.cache.Set(cacheKey, offerings, cache.DefaultExpiration)
+
+	log.FromContext(ctx).Info("Listed disk offerings", "zone", zone, "count", len(offerings))
+
+	return offerings, nil
+}
+
+// Resolve returns the disk offering matching the given name or ID in a zone
+func (p *DefaultProvider) Resolve(ctx context.Context, nameOrID string, zone string) (*DiskOffering, error) {
+	offerings, err := p.List(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	// Match by ID first, then by name
+	offering, found := lo.Find(offerings, func(o *DiskOffering) bool {
+		return o.ID == nameOrID
+	})
+	if !found {
+		offering, found = lo.Find(offerings, func(o *DiskOffering) bool {
+			return o.Name == nameOrID
+		})
+	}
+
+	if !found {
+		return nil, fmt.Errorf("disk offering %s not found in zone %s", nameOrID, zone)
+	}
+
+	return offering, nil
+}
+
+// ResolveRootDiskOffering returns the disk offering matching the root disk offering selector in a zone.
+// When several offerings match, the first one by name is returned so that the choice is stable.
+func (p *DefaultProvider) ResolveRootDiskOffering(ctx context.Context, selector *v1.RootDiskOfferingSelector, zone string) (*DiskOffering, error) {
+	offerings, err := p.List(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := lo.Filter(offerings, func(o *DiskOffering, _ int) bool {
+		if selector.ID != "" && o.ID != selector.ID {
+			return false
+		}
+		if selector.Name != "" && o.Name != selector.Name {
+			return false
+		}
+		if selector.ProvisioningType != "" && o.ProvisioningType != selector.ProvisioningType {
+			return false
+		}
+		return matchesStorageTags(o.StorageTags, selector.StorageTags)
+	})
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no root disk offering matched the selector in zone %s", zone)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Name < matched[j].Name
+	})
+
+	return matched[0], nil
+}
+
+// matchesStorageTags checks if the comma-separated offering storage tags contain all selector tags
+func matchesStorageTags(offeringTags string, selectorTags []string) bool {
+	tags := lo.Map(strings.Split(offeringTags, ","), func(t string, _ int) string {
+		return strings.TrimSpace(t)
+	})
+	return lo.Every(tags, selectorTags)
+}
+
+// Validate checks that the requested size and IOPS are compatible with the disk offering
+func Validate(offering *DiskOffering, size, minIOPS, maxIOPS *int64) error {
+	if offering.State != "" && offering.State != "Active" {
+		return fmt.Errorf("disk offering %s is not active (state: %s)", offering.Name, offering.State)
+	}
+
+	if offering.IsCustomized && size == nil {
+		return fmt.Errorf("disk offering %s is customized and requires a size", offering.Name)
+	}
+	if !offering.IsCustomized && size != nil {
+		return fmt.Errorf("disk offering %s has a fixed size of %dGB, size cannot be set", offering.Name, offering.DiskSize)
+	}
+
+	if !offering.IsCustomizedIOPS && (minIOPS != nil || maxIOPS != nil) {
+		return fmt.Errorf("disk offering %s does not support custom IOPS", offering.Name)
+	}
+	if offering.IsCustomizedIOPS && (minIOPS == nil || maxIOPS == nil) {
+		return fmt.Errorf("disk offering %s has customized IOPS and requires both minIOPS and maxIOPS", offering.Name)
+	}
+	if minIOPS != nil && maxIOPS != nil && *minIOPS > *maxIOPS {
+		return fmt.Errorf("minIOPS %d is greater than maxIOPS %d", *minIOPS, *maxIOPS)
+	}
+
+	return nil
+}
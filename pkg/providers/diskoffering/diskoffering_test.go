@@ -0,0 +1,189 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diskoffering
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+)
+
+const testZone = "zone-1"
+
+// newTestProvider returns a provider listing the given disk offerings in testZone
+func newTestProvider(offerings []*DiskOffering) *DefaultProvider {
+	c := cache.New(time.Hour, time.Hour)
+	c.SetDefault("disk-offerings-"+testZone, offerings)
+	return NewDefaultProvider(nil, c)
+}
+
+func TestResolve(t *testing.T) {
+	p := newTestProvider([]*DiskOffering{
+		{ID: "offering-1", Name: "small"},
+		{ID: "offering-2", Name: "offering-1"},
+	})
+	tests := []struct {
+		name     string
+		nameOrID string
+		want     string
+		wantErr  bool
+	}{
+		{name: "ID", nameOrID: "offering-2", want: "offering-2"},
+		{name: "name", nameOrID: "small", want: "offering-1"},
+		{name: "ID takes precedence over name", nameOrID: "offering-1", want: "offering-1"},
+		{name: "not found", nameOrID: "large", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offering, err := p.Resolve(context.Background(), tt.nameOrID, testZone)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if offering != nil && offering.ID != tt.want {
+				t.Errorf("Resolve() = %s, want %s", offering.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		offering *DiskOffering
+		size     *int64
+		minIOPS  *int64
+		maxIOPS  *int64
+		wantErr  bool
+	}{
+		{
+			name:     "fixed size",
+			offering: &DiskOffering{Name: "fixed", DiskSize: 20, State: "Active"},
+		},
+		{
+			name:     "fixed size with a size",
+			offering: &DiskOffering{Name: "fixed", DiskSize: 20},
+			size:     lo.ToPtr[int64](50),
+			wantErr:  true,
+		},
+		{
+			name:     "customized size",
+			offering: &DiskOffering{Name: "custom", IsCustomized: true},
+			size:     lo.ToPtr[int64](50),
+		},
+		{
+			name:     "customized size without a size",
+			offering: &DiskOffering{Name: "custom", IsCustomized: true},
+			wantErr:  true,
+		},
+		{
+			name:     "inactive offering",
+			offering: &DiskOffering{Name: "fixed", DiskSize: 20, State: "Inactive"},
+			wantErr:  true,
+		},
+		{
+			name:     "customized IOPS",
+			offering: &DiskOffering{Name: "iops", DiskSize: 20, IsCustomizedIOPS: true},
+			minIOPS:  lo.ToPtr[int64](1000),
+			maxIOPS:  lo.ToPtr[int64](2000),
+		},
+		{
+			name:     "customized IOPS without a maximum",
+			offering: &DiskOffering{Name: "iops", DiskSize: 20, IsCustomizedIOPS: true},
+			minIOPS:  lo.ToPtr[int64](1000),
+			wantErr:  true,
+		},
+		{
+			name:     "minimum IOPS greater than the maximum",
+			offering: &DiskOffering{Name: "iops", DiskSize: 20, IsCustomizedIOPS: true},
+			minIOPS:  lo.ToPtr[int64](2000),
+			maxIOPS:  lo.ToPtr[int64](1000),
+			wantErr:  true,
+		},
+		{
+			name:     "IOPS on an offering without customized IOPS",
+			offering: &DiskOffering{Name: "fixed", DiskSize: 20},
+			maxIOPS:  lo.ToPtr[int64](2000),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.offering, tt.size, tt.minIOPS, tt.maxIOPS); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveRootDiskOffering(t *testing.T) {
+	p := newTestProvider([]*DiskOffering{
+		{ID: "offering-1", Name: "ssd-b", StorageTags: "ssd, fast", ProvisioningType: "thin"},
+		{ID: "offering-2", Name: "ssd-a", StorageTags: "ssd", ProvisioningType: "sparse"},
+		{ID: "offering-3", Name: "hdd", StorageTags: "hdd", ProvisioningType: "thin"},
+	})
+	tests := []struct {
+		name     string
+		selector *v1.RootDiskOfferingSelector
+		want     string
+		wantErr  bool
+	}{
+		{name: "ID", selector: &v1.RootDiskOfferingSelector{ID: "offering-3"}, want: "offering-3"},
+		{name: "name", selector: &v1.RootDiskOfferingSelector{Name: "ssd-b"}, want: "offering-1"},
+		{name: "storage tags", selector: &v1.RootDiskOfferingSelector{StorageTags: []string{"fast", "ssd"}}, want: "offering-1"},
+		{name: "first match by name", selector: &v1.RootDiskOfferingSelector{StorageTags: []string{"ssd"}}, want: "offering-2"},
+		{name: "provisioning type", selector: &v1.RootDiskOfferingSelector{StorageTags: []string{"ssd"}, ProvisioningType: "thin"}, want: "offering-1"},
+		{name: "all criteria must match", selector: &v1.RootDiskOfferingSelector{ID: "offering-3", StorageTags: []string{"ssd"}}, wantErr: true},
+		{name: "no match", selector: &v1.RootDiskOfferingSelector{StorageTags: []string{"nvme"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offering, err := p.ResolveRootDiskOffering(context.Background(), tt.selector, testZone)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveRootDiskOffering() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if offering != nil && offering.ID != tt.want {
+				t.Errorf("ResolveRootDiskOffering() = %s, want %s", offering.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesStorageTags(t *testing.T) {
+	tests := []struct {
+		name         string
+		offeringTags string
+		selectorTags []string
+		want         bool
+	}{
+		{name: "no selector tags", offeringTags: "ssd", want: true},
+		{name: "no selector tags on an untagged offering", want: true},
+		{name: "subset", offeringTags: "ssd,fast", selectorTags: []string{"fast"}, want: true},
+		{name: "whitespace is trimmed", offeringTags: "ssd, fast", selectorTags: []string{"ssd", "fast"}, want: true},
+		{name: "missing tag", offeringTags: "ssd", selectorTags: []string{"ssd", "fast"}},
+		{name: "untagged offering", selectorTags: []string{"ssd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesStorageTags(tt.offeringTags, tt.selectorTags); got != tt.want {
+				t.Errorf("matchesStorageTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -16,20 +16,26 @@ package instance
 
 import (
 	"context"
-	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/apache/cloudstack-go/v2/cloudstack"
 	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
 	"sigs.k8s.io/karpenter/pkg/cloudprovider"
 
 	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
 	csapi "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudstack"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/bootstraptoken"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/network"
 	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/userdata"
 )
 
 // Provider provides instance management
@@ -38,12 +44,16 @@ type Provider interface {
 	Get(ctx context.Context, id string) (*Instance, error)
 	List(ctx context.Context) ([]*Instance, error)
 	Delete(ctx context.Context, id string) error
+	Tags(nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim) map[string]string
+	UpdateTags(ctx context.Context, id string, tags map[string]string, removed []string) error
+	UpdateDisplayName(ctx context.Context, id string, displayName string) error
 }
 
 // Instance represents a CloudStack virtual machine
 type Instance struct {
 	ID                string
 	Name              string
+	DisplayName       string
 	State             string
 	Zone              string
 	ZoneID            string
@@ -52,18 +62,23 @@ type Instance struct {
 	Template          string
 	TemplateID        string
 	NetworkID         string
+	DiskOfferingID    string
 	IPAddress         string
+	SSHKeyPairs       []string
 	CreatedTime       time.Time
 	Tags              map[string]string
 }
 
 // DefaultProvider implements the Instance Provider
 type DefaultProvider struct {
-	csClient         csapi.CloudStackAPI
-	networkProvider  network.Provider
-	templateProvider template.Provider
-	cache            *cache.Cache
-	clusterName      string
+	csClient               csapi.CloudStackAPI
+	networkProvider        network.Provider
+	templateProvider       template.Provider
+	diskOfferingProvider   diskoffering.Provider
+	userDataProvider       userdata.Provider
+	bootstrapTokenProvider bootstraptoken.Provider
+	cache                  *cache.Cache
+	clusterName            string
 }
 
 // NewDefaultProvider creates a new instance provider
@@ -71,20 +86,39 @@ func NewDefaultProvider(
 	csClient csapi.CloudStackAPI,
 	networkProvider network.Provider,
 	templateProvider template.Provider,
+	diskOfferingProvider diskoffering.Provider,
+	userDataProvider userdata.Provider,
+	bootstrapTokenProvider bootstraptoken.Provider,
 	cache *cache.Cache,
 	clusterName string,
 ) *DefaultProvider {
 	return &DefaultProvider{
-		csClient:         csClient,
-		networkProvider:  networkProvider,
-		templateProvider: templateProvider,
-		cache:            cache,
-		clusterName:      clusterName,
+		csClient:               csClient,
+		networkProvider:        networkProvider,
+		templateProvider:       templateProvider,
+		diskOfferingProvider:   diskOfferingProvider,
+		userDataProvider:       userDataProvider,
+		bootstrapTokenProvider: bootstrapTokenProvider,
+		cache:                  cache,
+		clusterName:            clusterName,
 	}
 }
 
 // Create creates a new virtual machine
 func (p *DefaultProvider) Create(ctx context.Context, nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim, instanceTypes []*cloudprovider.InstanceType) (*Instance, error) {
+	instance, err := p.launch(ctx, nodeClass, nodeClaim, instanceTypes)
+	if err != nil {
+		// Delete the launch tokens minted for the NodeClaim, as no node joins with them
+		if err := p.bootstrapTokenProvider.DeleteForNodeClaim(ctx, nodeClaim.Name); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to delete bootstrap tokens", "nodeClaim", nodeClaim.Name)
+		}
+		return nil, err
+	}
+	return instance, nil
+}
+
+// launch deploys the virtual machine of a NodeClaim
+func (p *DefaultProvider) launch(ctx context.Context, nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim, instanceTypes []*cloudprovider.InstanceType) (*Instance, error) {
 	log.FromContext(ctx).Info("Creating instance", "nodeClaim", nodeClaim.Name)
 
 	// Select instance type (service offering)
@@ -117,7 +151,7 @@ func (p *DefaultProvider) Create(ctx context.Context, nodeClass *v1.CloudStackNo
 	if len(templates) == 0 {
 		return nil, fmt.Errorf("no templates found")
 	}
-	templateID := templates[0].ID
+	selectedTemplate := templates[0]
 
 	// Get service offering ID
 	serviceOfferingID, _, err := p.csClient.(*csapi.Client).ServiceOffering.GetServiceOfferingID(instanceType.Name)
@@ -128,10 +162,19 @@ func (p *DefaultProvider) Create(ctx context.Context, nodeClass *v1.CloudStackNo
 	// Prepare deploy parameters
 	deployParams := p.csClient.(*csapi.Client).VirtualMachine.NewDeployVirtualMachineParams(
 		serviceOfferingID,
-		templateID,
+		selectedTemplate.ID,
 		zoneID,
 	)
 
+	// Boot the VM the way the template expects, on the hypervisor the template is built for
+	if setsBootOptions(selectedTemplate) {
+		deployParams.SetBoottype(selectedTemplate.BootType)
+		deployParams.SetBootmode(selectedTemplate.BootMode)
+	}
+	if selectedTemplate.Hypervisor != "" {
+		deployParams.SetHypervisor(selectedTemplate.Hypervisor)
+	}
+
 	// Set network
 	deployParams.SetNetworkids([]string{networkID})
 
@@ -141,14 +184,30 @@ func (p *DefaultProvider) Create(ctx context.Context, nodeClass *v1.CloudStackNo
 	deployParams.SetDisplayname(vmName)
 
 	// Set user data if provided
-	if nodeClass.Spec.UserData != nil && *nodeClass.Spec.UserData != "" {
-		userData := base64.StdEncoding.EncodeToString([]byte(*nodeClass.Spec.UserData))
-		deployParams.SetUserdata(userData)
+	userData, err := p.userDataProvider.Generate(ctx, nodeClass, nodeClaim, instanceType.Name)
+	if err != nil {
+		return nil, fmt.Errorf("generating user data: %w", err)
+	}
+	if userData != "" {
+		encoded, err := userdata.Encode(ctx, nodeClass, userData)
+		if err != nil {
+			return nil, err
+		}
+		deployParams.SetUserdata(encoded)
 	}
 
 	// Set root disk size if specified
-	if nodeClass.Spec.RootDiskSize != nil {
-		deployParams.SetRootdisksize(*nodeClass.Spec.RootDiskSize)
+	if size := rootDiskSize(nodeClass.Spec.RootDiskSize, selectedTemplate); size != nil {
+		deployParams.SetRootdisksize(*size)
+	}
+
+	// Override the root disk offering if specified
+	if nodeClass.Spec.RootDiskOffering != nil {
+		rootDiskOffering, err := p.diskOfferingProvider.ResolveRootDiskOffering(ctx, nodeClass.Spec.RootDiskOffering, nodeClass.Spec.Zone)
+		if err != nil {
+			return nil, fmt.Errorf("resolving root disk offering: %w", err)
+		}
+		deployParams.SetOverridediskofferingid(rootDiskOffering.ID)
 	}
 
 	// Set SSH key pair if specified
@@ -156,6 +215,40 @@ func (p *DefaultProvider) Create(ctx context.Context, nodeClass *v1.CloudStackNo
 		deployParams.SetKeypair(*nodeClass.Spec.SSHKeyPair)
 	}
 
+	// Additional deploy details passed through to CloudStack
+	details := map[string]string{}
+
+	if nodeClass.Spec.RootDiskController != nil {
+		details["rootDiskController"] = *nodeClass.Spec.RootDiskController
+	}
+
+	// Attach a data disk if a disk offering is specified
+	var diskOfferingID string
+	if nodeClass.Spec.DiskOffering != nil {
+		diskOffering, err := p.diskOfferingProvider.Resolve(ctx, *nodeClass.Spec.DiskOffering, nodeClass.Spec.Zone)
+		if err != nil {
+			return nil, fmt.Errorf("resolving disk offering: %w", err)
+		}
+		if err := diskoffering.Validate(diskOffering, nodeClass.Spec.DataDiskSize, nodeClass.Spec.DataDiskMinIOPS, nodeClass.Spec.DataDiskMaxIOPS); err != nil {
+			return nil, fmt.Errorf("validating disk offering: %w", err)
+		}
+		diskOfferingID = diskOffering.ID
+		deployParams.SetDiskofferingid(diskOfferingID)
+
+		if diskOffering.IsCustomized {
+			deployParams.SetSize(*nodeClass.Spec.DataDiskSize)
+		}
+		// minIops and maxIops apply to the root disk, the data disk takes the "Do" details
+		if diskOffering.IsCustomizedIOPS {
+			details["minIopsDo"] = fmt.Sprint(*nodeClass.Spec.DataDiskMinIOPS)
+			details["maxIopsDo"] = fmt.Sprint(*nodeClass.Spec.DataDiskMaxIOPS)
+		}
+	}
+
+	if len(details) > 0 {
+		deployParams.SetDetails(details)
+	}
+
 	// Deploy the VM
 	resp, err := p.csClient.DeployVirtualMachine(deployParams)
 	if err != nil {
@@ -170,12 +263,30 @@ func (p *DefaultProvider) Create(ctx context.Context, nodeClass *v1.CloudStackNo
 
 	// Create tags
 	tags := p.buildTags(nodeClass, nodeClaim)
-	if err := p.createTags(ctx, vm.Id, tags); err != nil {
+	if err := p.createTags(ctx, vm.Id, "UserVm", tags); err != nil {
 		log.FromContext(ctx).Error(err, "Failed to create tags", "vmID", vm.Id)
-		// Don't fail the creation if tagging fails
+		// Don't fail the creation if tagging fails, the NodeClaim tagging controller sets the missing tags
+	}
+
+	// Mark the data disk attached at deploy time for deletion along with the VM. The launch fails when
+	// the disk can't be marked, as it would be left behind when the node is deleted.
+	if diskOfferingID != "" {
+		if err := p.tagDataVolumes(ctx, vm.Id, tags); err != nil {
+			p.cleanup(ctx, vm.Id, nil)
+			return nil, fmt.Errorf("tagging data volumes: %w", err)
+		}
+	}
+
+	// Create and attach additional volumes
+	if volumeIDs, err := p.createVolumes(ctx, nodeClass, vm.Id, zoneID, vmName, tags); err != nil {
+		// The volumes attached so far are deleted whether they're marked for deletion on termination
+		// or not, as the VM never became a node
+		p.cleanup(ctx, vm.Id, volumeIDs)
+		return nil, fmt.Errorf("creating volumes: %w", err)
 	}
 
 	instance := p.convertToInstance(vm, tags)
+	instance.DiskOfferingID = diskOfferingID
 
 	log.FromContext(ctx).Info("Instance created successfully", "instanceID", instance.ID, "name", instance.Name)
 
@@ -205,6 +316,11 @@ func (p *DefaultProvider) Get(ctx context.Context, id string) (*Instance, error)
 	vm := resp.VirtualMachines[0]
 	tags, _ := p.getTags(ctx, vm.Id)
 	instance := p.convertToInstance(vm, tags)
+	if diskOfferingIDs, err := p.getDataDiskOfferingIDs(ctx, vm.Id); err != nil {
+		log.FromContext(ctx).V(1).Info("Failed to get data disk for VM", "vmID", vm.Id, "error", err)
+	} else {
+		instance.DiskOfferingID = diskOfferingIDs[vm.Id]
+	}
 
 	// Cache the result
 	p.cache.Set(cacheKey, instance, cache.DefaultExpiration)
@@ -222,6 +338,12 @@ func (p *DefaultProvider) List(ctx context.Context) ([]*Instance, error) {
 		return nil, fmt.Errorf("listing instances: %w", err)
 	}
 
+	// The data disks of all VMs are listed at once rather than per VM
+	diskOfferingIDs, err := p.getDataDiskOfferingIDs(ctx, "")
+	if err != nil {
+		log.FromContext(ctx).V(1).Info("Failed to get data disks", "error", err)
+	}
+
 	instances := make([]*Instance, 0)
 	for _, vm := range resp.VirtualMachines {
 		// Get tags to check if this is a Karpenter-managed VM
@@ -234,6 +356,7 @@ func (p *DefaultProvider) List(ctx context.Context) ([]*Instance, error) {
 		// Only include VMs with Karpenter tags
 		if _, hasTag := tags[v1.ManagedByTagKey]; hasTag {
 			instance := p.convertToInstance(vm, tags)
+			instance.DiskOfferingID = diskOfferingIDs[vm.Id]
 			instances = append(instances, instance)
 		}
 	}
@@ -257,9 +380,18 @@ func (p *DefaultProvider) Delete(ctx context.Context, id string) error {
 		return fmt.Errorf("checking instance existence: %w", err)
 	}
 
+	// Collect data volumes that are deleted along with the VM
+	volumeIDs, err := p.getDeleteOnTerminationVolumes(ctx, id)
+	if err != nil {
+		return fmt.Errorf("listing volumes for instance %s: %w", id, err)
+	}
+
 	// Destroy the VM
 	params := p.csClient.(*csapi.Client).VirtualMachine.NewDestroyVirtualMachineParams(id)
 	params.SetExpunge(true)
+	if len(volumeIDs) > 0 {
+		params.SetVolumeids(volumeIDs)
+	}
 
 	_, err = p.csClient.DestroyVirtualMachine(params)
 	if err != nil {
@@ -275,6 +407,28 @@ func (p *DefaultProvider) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// cleanup destroys a VM whose launch failed along with its data volumes: the given volumes created
+// during the launch, and the ones listed as attached to the VM. None of them belongs to a node yet.
+func (p *DefaultProvider) cleanup(ctx context.Context, vmID string, volumeIDs []string) {
+	attached, err := p.getDataVolumes(ctx, vmID)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list data volumes of failed instance", "vmID", vmID)
+	}
+	volumeIDs = lo.Uniq(append(volumeIDs, attached...))
+
+	params := p.csClient.(*csapi.Client).VirtualMachine.NewDestroyVirtualMachineParams(vmID)
+	params.SetExpunge(true)
+	if len(volumeIDs) > 0 {
+		params.SetVolumeids(volumeIDs)
+	}
+	if _, err := p.csClient.DestroyVirtualMachine(params); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to delete failed instance", "vmID", vmID, "volumeIDs", volumeIDs)
+		return
+	}
+	p.cache.Delete(fmt.Sprintf("instance-%s", vmID))
+	log.FromContext(ctx).Info("Deleted failed instance", "vmID", vmID, "volumeIDs", volumeIDs)
+}
+
 // selectInstanceType selects the best instance type based on node claim requirements
 func (p *DefaultProvider) selectInstanceType(nodeClaim *karpv1.NodeClaim, instanceTypes []*cloudprovider.InstanceType) *cloudprovider.InstanceType {
 	// For now, select the first instance type that's available
@@ -323,6 +477,11 @@ func (p *DefaultProvider) waitForVMState(ctx context.Context, vmID, targetState
 	}
 }
 
+// Tags returns the tags the VM of a NodeClaim carries
+func (p *DefaultProvider) Tags(nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim) map[string]string {
+	return p.buildTags(nodeClass, nodeClaim)
+}
+
 // buildTags builds tags for the VM
 func (p *DefaultProvider) buildTags(nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim) map[string]string {
 	tags := map[string]string{
@@ -345,11 +504,153 @@ func (p *DefaultProvider) buildTags(nodeClass *v1.CloudStackNodeClass, nodeClaim
 	return tags
 }
 
+// createVolumes creates the NodeClass block device mappings and attaches them to the VM. The IDs of the
+// volumes attached are returned, including when attaching a later volume fails.
+func (p *DefaultProvider) createVolumes(ctx context.Context, nodeClass *v1.CloudStackNodeClass, vmID, zoneID, vmName string, tags map[string]string) ([]string, error) {
+	var volumeIDs []string
+	for i, mapping := range nodeClass.Spec.BlockDeviceMappings {
+		diskOffering, err := p.diskOfferingProvider.Resolve(ctx, mapping.DiskOffering, nodeClass.Spec.Zone)
+		if err != nil {
+			return volumeIDs, fmt.Errorf("resolving disk offering for block device mapping %d: %w", i, err)
+		}
+		if err := diskoffering.Validate(diskOffering, mapping.Size, mapping.MinIOPS, mapping.MaxIOPS); err != nil {
+			return volumeIDs, fmt.Errorf("validating block device mapping %d: %w", i, err)
+		}
+
+		params := p.csClient.(*csapi.Client).Volume.NewCreateVolumeParams()
+		params.SetName(fmt.Sprintf("%s-data-%d", vmName, i))
+		params.SetZoneid(zoneID)
+		params.SetDiskofferingid(diskOffering.ID)
+		if diskOffering.IsCustomized {
+			params.SetSize(*mapping.Size)
+		}
+		if diskOffering.IsCustomizedIOPS {
+			params.SetMiniops(*mapping.MinIOPS)
+			params.SetMaxiops(*mapping.MaxIOPS)
+		}
+
+		volume, err := p.csClient.CreateVolume(params)
+		if err != nil {
+			return volumeIDs, fmt.Errorf("creating volume for block device mapping %d: %w", i, err)
+		}
+
+		volumeTags := lo.Assign(tags, map[string]string{
+			v1.DeleteOnTerminationTagKey: strconv.FormatBool(lo.FromPtrOr(mapping.DeleteOnTermination, true)),
+		})
+		if err := p.createTags(ctx, volume.Id, "Volume", volumeTags); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to create tags", "volumeID", volume.Id)
+		}
+
+		attachParams := p.csClient.(*csapi.Client).Volume.NewAttachVolumeParams(volume.Id, vmID)
+		if mapping.DeviceID != nil {
+			attachParams.SetDeviceid(*mapping.DeviceID)
+		}
+		if _, err := p.csClient.AttachVolume(attachParams); err != nil {
+			// The volume isn't attached yet, so it won't be removed with the VM
+			if _, derr := p.csClient.DeleteVolume(p.csClient.(*csapi.Client).Volume.NewDeleteVolumeParams(volume.Id)); derr != nil {
+				log.FromContext(ctx).Error(derr, "Failed to delete unattached volume", "volumeID", volume.Id)
+			}
+			return volumeIDs, fmt.Errorf("attaching volume %s to VM %s: %w", volume.Id, vmID, err)
+		}
+		volumeIDs = append(volumeIDs, volume.Id)
+
+		log.FromContext(ctx).Info("Attached volume", "volumeID", volume.Id, "vmID", vmID)
+	}
+
+	return volumeIDs, nil
+}
+
+// tagDataVolumes tags the data volumes currently attached to the VM for deletion on termination
+func (p *DefaultProvider) tagDataVolumes(ctx context.Context, vmID string, tags map[string]string) error {
+	params := p.csClient.(*csapi.Client).Volume.NewListVolumesParams()
+	params.SetVirtualmachineid(vmID)
+	params.SetType("DATADISK")
+
+	resp, err := p.csClient.ListVolumes(params)
+	if err != nil {
+		return err
+	}
+
+	volumeTags := lo.Assign(tags, map[string]string{
+		v1.DeleteOnTerminationTagKey: "true",
+	})
+	for _, volume := range resp.Volumes {
+		if err := p.createTags(ctx, volume.Id, "Volume", volumeTags); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getDataVolumes returns the IDs of the data volumes attached to the VM
+func (p *DefaultProvider) getDataVolumes(ctx context.Context, vmID string) ([]string, error) {
+	params := p.csClient.(*csapi.Client).Volume.NewListVolumesParams()
+	params.SetVirtualmachineid(vmID)
+	params.SetType("DATADISK")
+
+	resp, err := p.csClient.ListVolumes(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.Map(resp.Volumes, func(v *cloudstack.Volume, _ int) string {
+		return v.Id
+	}), nil
+}
+
+// getDataDiskOfferingIDs returns the disk offering of the data disk attached at deploy time by VM ID,
+// for the given VM or for all VMs when vmID is empty
+func (p *DefaultProvider) getDataDiskOfferingIDs(ctx context.Context, vmID string) (map[string]string, error) {
+	params := p.csClient.(*csapi.Client).Volume.NewListVolumesParams()
+	if vmID != "" {
+		params.SetVirtualmachineid(vmID)
+	}
+	params.SetType("DATADISK")
+
+	resp, err := p.csClient.ListVolumes(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataDiskOfferingIDs(resp.Volumes), nil
+}
+
+// dataDiskOfferingIDs returns the disk offering of the data disk attached at deploy time by VM ID.
+// The volumes of block device mappings, named after the VM by createVolumes, are skipped.
+func dataDiskOfferingIDs(volumes []*cloudstack.Volume) map[string]string {
+	diskOfferingIDs := map[string]string{}
+	for _, volume := range volumes {
+		if volume.Virtualmachineid == "" || strings.HasPrefix(volume.Name, volume.Vmname+"-data-") {
+			continue
+		}
+		diskOfferingIDs[volume.Virtualmachineid] = volume.Diskofferingid
+	}
+	return diskOfferingIDs
+}
+
+// getDeleteOnTerminationVolumes returns the IDs of the VM data volumes marked for deletion on termination
+func (p *DefaultProvider) getDeleteOnTerminationVolumes(ctx context.Context, vmID string) ([]string, error) {
+	params := p.csClient.(*csapi.Client).Volume.NewListVolumesParams()
+	params.SetVirtualmachineid(vmID)
+	params.SetType("DATADISK")
+	params.SetTags(map[string]string{v1.DeleteOnTerminationTagKey: "true"})
+
+	resp, err := p.csClient.ListVolumes(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.Map(resp.Volumes, func(v *cloudstack.Volume, _ int) string {
+		return v.Id
+	}), nil
+}
+
 // createTags creates tags for a resource
-func (p *DefaultProvider) createTags(ctx context.Context, resourceID string, tags map[string]string) error {
+func (p *DefaultProvider) createTags(ctx context.Context, resourceID, resourceType string, tags map[string]string) error {
 	params := p.csClient.(*csapi.Client).Resourcetags.NewCreateTagsParams(
 		[]string{resourceID},
-		"UserVm",
+		resourceType,
 		tags,
 	)
 
@@ -357,11 +658,16 @@ func (p *DefaultProvider) createTags(ctx context.Context, resourceID string, tag
 	return err
 }
 
-// getTags fetches tags for a resource
+// getTags fetches tags for a VM
 func (p *DefaultProvider) getTags(ctx context.Context, resourceID string) (map[string]string, error) {
+	return p.getResourceTags(ctx, resourceID, "UserVm")
+}
+
+// getResourceTags fetches tags for a resource of the given type
+func (p *DefaultProvider) getResourceTags(ctx context.Context, resourceID, resourceType string) (map[string]string, error) {
 	params := p.csClient.(*csapi.Client).Resourcetags.NewListTagsParams()
 	params.SetResourceid(resourceID)
-	params.SetResourcetype("UserVm")
+	params.SetResourcetype(resourceType)
 
 	resp, err := p.csClient.ListTags(params)
 	if err != nil {
@@ -376,6 +682,96 @@ func (p *DefaultProvider) getTags(ctx context.Context, resourceID string) (map[s
 	return tags, nil
 }
 
+// UpdateTags updates the tags of an instance and its data volumes in place: the given tags are set,
+// replacing the ones with a different value, and the removed tag keys are deleted
+func (p *DefaultProvider) UpdateTags(ctx context.Context, id string, tags map[string]string, removed []string) error {
+	updated, err := p.updateResourceTags(ctx, id, "UserVm", tags, removed)
+	if err != nil {
+		return fmt.Errorf("updating tags of instance %s: %w", id, err)
+	}
+	if updated {
+		p.cache.Delete(fmt.Sprintf("instance-%s", id))
+	}
+
+	volumeIDs, err := p.getDataVolumes(ctx, id)
+	if err != nil {
+		return fmt.Errorf("listing data volumes of instance %s: %w", id, err)
+	}
+	for _, volumeID := range volumeIDs {
+		if _, err := p.updateResourceTags(ctx, volumeID, "Volume", tags, removed); err != nil {
+			return fmt.Errorf("updating tags of volume %s: %w", volumeID, err)
+		}
+	}
+	return nil
+}
+
+// updateResourceTags updates the tags of a resource in place, returning whether any tag changed
+func (p *DefaultProvider) updateResourceTags(ctx context.Context, id, resourceType string, tags map[string]string, removed []string) (bool, error) {
+	current, err := p.getResourceTags(ctx, id, resourceType)
+	if err != nil {
+		return false, fmt.Errorf("getting tags: %w", err)
+	}
+
+	stale, missing := diffTags(current, tags, removed)
+	// Deleting tags without keys would delete all of them
+	if len(stale) > 0 {
+		params := p.csClient.(*csapi.Client).Resourcetags.NewDeleteTagsParams([]string{id}, resourceType)
+		params.SetTags(stale)
+		if _, err := p.csClient.DeleteTags(params); err != nil {
+			return false, fmt.Errorf("deleting tags: %w", err)
+		}
+	}
+	if len(missing) > 0 {
+		if err := p.createTags(ctx, id, resourceType, missing); err != nil {
+			return false, fmt.Errorf("creating tags: %w", err)
+		}
+	}
+	if len(stale) == 0 && len(missing) == 0 {
+		return false, nil
+	}
+	log.FromContext(ctx).Info("Updated tags", "resourceType", resourceType, "resourceID", id, "deleted", lo.Keys(stale), "created", lo.Keys(missing))
+	return true, nil
+}
+
+// diffTags returns the current tags to delete and the tags to create for an instance to carry the given
+// tags, given the keys previously set that may be removed. CloudStack doesn't update tag values, so
+// changed tags are deleted and created again.
+func diffTags(current, tags map[string]string, removed []string) (stale, missing map[string]string) {
+	stale = map[string]string{}
+	for _, key := range removed {
+		if _, ok := tags[key]; ok {
+			continue
+		}
+		if value, ok := current[key]; ok {
+			stale[key] = value
+		}
+	}
+	missing = map[string]string{}
+	for key, value := range tags {
+		currentValue, ok := current[key]
+		if ok && currentValue == value {
+			continue
+		}
+		if ok {
+			stale[key] = currentValue
+		}
+		missing[key] = value
+	}
+	return stale, missing
+}
+
+// UpdateDisplayName sets the display name of an instance
+func (p *DefaultProvider) UpdateDisplayName(ctx context.Context, id string, displayName string) error {
+	params := p.csClient.(*csapi.Client).VirtualMachine.NewUpdateVirtualMachineParams(id)
+	params.SetDisplayname(displayName)
+	if _, err := p.csClient.UpdateVirtualMachine(params); err != nil {
+		return fmt.Errorf("updating display name of instance %s: %w", id, err)
+	}
+	p.cache.Delete(fmt.Sprintf("instance-%s", id))
+	log.FromContext(ctx).Info("Updated instance display name", "instanceID", id, "displayName", displayName)
+	return nil
+}
+
 // convertToInstance converts a CloudStack VM to an Instance
 func (p *DefaultProvider) convertToInstance(vm *cloudstack.VirtualMachine, tags map[string]string) *Instance {
 	// Parse creation time from CloudStack date string
@@ -384,6 +780,7 @@ func (p *DefaultProvider) convertToInstance(vm *cloudstack.VirtualMachine, tags
 	return &Instance{
 		ID:                vm.Id,
 		Name:              vm.Name,
+		DisplayName:       vm.Displayname,
 		State:             vm.State,
 		Zone:              vm.Zonename,
 		ZoneID:            vm.Zoneid,
@@ -393,6 +790,7 @@ func (p *DefaultProvider) convertToInstance(vm *cloudstack.VirtualMachine, tags
 		TemplateID:        vm.Templateid,
 		NetworkID:         getFirstNetworkID(vm.Nic),
 		IPAddress:         getFirstIPAddress(vm.Nic),
+		SSHKeyPairs:       lo.Compact(strings.Split(vm.Keypairs, ",")),
 		CreatedTime:       createdTime,
 		Tags:              tags,
 	}
@@ -422,6 +820,22 @@ func parseCloudStackTime(timeStr string) time.Time {
 	return time.Time{}
 }
 
+// rootDiskSize returns the root disk size in GB to deploy a template with. Templates grow with new
+// releases, so the size set in the NodeClass is raised to the template size, rounded up to GB, when
+// it's smaller. No size is returned when the NodeClass doesn't set one, leaving it to the template.
+func rootDiskSize(size *int64, t *template.Template) *int64 {
+	if size == nil {
+		return nil
+	}
+	return lo.ToPtr(max(*size, (t.Size+1<<30-1)>>30))
+}
+
+// setsBootOptions reports whether a template is deployed with its boot type and mode. CloudStack only
+// accepts them on KVM and VMware, and BIOS templates boot the same without them on other hypervisors.
+func setsBootOptions(t *template.Template) bool {
+	return t.BootType == v1.BootTypeUEFI || strings.EqualFold(t.Hypervisor, "KVM") || strings.EqualFold(t.Hypervisor, "VMware")
+}
+
 // getFirstNetworkID returns the first network ID from NICs
 func getFirstNetworkID(nics []cloudstack.Nic) string {
 	if len(nics) > 0 {
@@ -437,3 +851,17 @@ func getFirstIPAddress(nics []cloudstack.Nic) string {
 	}
 	return ""
 }
+
+// FormatTags returns the tags as JSON, as recorded on NodeClaims
+func FormatTags(tags map[string]string) string {
+	return string(lo.Must(json.Marshal(lo.Assign(tags))))
+}
+
+// ParseTags parses tags formatted by FormatTags
+func ParseTags(s string) map[string]string {
+	tags := map[string]string{}
+	if err := json.Unmarshal([]byte(s), &tags); err != nil {
+		return map[string]string{}
+	}
+	return tags
+}
@@ -0,0 +1,259 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/samber/lo"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+)
+
+func TestDiffTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     map[string]string
+		tags        map[string]string
+		removed     []string
+		wantStale   map[string]string
+		wantMissing map[string]string
+	}{
+		{
+			name:        "no changes",
+			current:     map[string]string{"team": "a", "env": "prod"},
+			tags:        map[string]string{"team": "a", "env": "prod"},
+			removed:     []string{"team", "env"},
+			wantStale:   map[string]string{},
+			wantMissing: map[string]string{},
+		},
+		{
+			name:        "missing tags are created",
+			current:     map[string]string{"team": "a"},
+			tags:        map[string]string{"team": "a", "env": "prod"},
+			wantStale:   map[string]string{},
+			wantMissing: map[string]string{"env": "prod"},
+		},
+		{
+			name:        "changed tags are deleted and created again",
+			current:     map[string]string{"team": "a"},
+			tags:        map[string]string{"team": "b"},
+			removed:     []string{"team"},
+			wantStale:   map[string]string{"team": "a"},
+			wantMissing: map[string]string{"team": "b"},
+		},
+		{
+			name:        "removed tags are deleted with their current value",
+			current:     map[string]string{"team": "a", "env": "prod"},
+			tags:        map[string]string{"team": "a"},
+			removed:     []string{"team", "env"},
+			wantStale:   map[string]string{"env": "prod"},
+			wantMissing: map[string]string{},
+		},
+		{
+			name:        "removed tags no longer on the instance are ignored",
+			current:     map[string]string{"team": "a"},
+			tags:        map[string]string{"team": "a"},
+			removed:     []string{"env"},
+			wantStale:   map[string]string{},
+			wantMissing: map[string]string{},
+		},
+		{
+			name:        "tags not previously set are kept",
+			current:     map[string]string{"team": "a", "owner": "ops"},
+			tags:        map[string]string{"team": "a"},
+			removed:     []string{"team"},
+			wantStale:   map[string]string{},
+			wantMissing: map[string]string{},
+		},
+		{
+			name:        "tags removed from the instance are repaired",
+			current:     map[string]string{},
+			tags:        map[string]string{"team": "a"},
+			removed:     []string{"team"},
+			wantStale:   map[string]string{},
+			wantMissing: map[string]string{"team": "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stale, missing := diffTags(tt.current, tt.tags, tt.removed)
+			if !reflect.DeepEqual(stale, tt.wantStale) {
+				t.Errorf("stale = %v, want %v", stale, tt.wantStale)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestDataDiskOfferingIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes []*cloudstack.Volume
+		want    map[string]string
+	}{
+		{
+			name: "data disk attached at deploy time",
+			volumes: []*cloudstack.Volume{
+				{Name: "DATA-12", Vmname: "karpenter-a", Virtualmachineid: "vm-1", Diskofferingid: "offering-1"},
+				{Name: "DATA-13", Vmname: "karpenter-b", Virtualmachineid: "vm-2", Diskofferingid: "offering-2"},
+			},
+			want: map[string]string{"vm-1": "offering-1", "vm-2": "offering-2"},
+		},
+		{
+			name: "block device mapping volumes are skipped",
+			volumes: []*cloudstack.Volume{
+				{Name: "karpenter-a-data-0", Vmname: "karpenter-a", Virtualmachineid: "vm-1", Diskofferingid: "offering-3"},
+				{Name: "DATA-12", Vmname: "karpenter-a", Virtualmachineid: "vm-1", Diskofferingid: "offering-1"},
+				{Name: "karpenter-b-data-0", Vmname: "karpenter-b", Virtualmachineid: "vm-2", Diskofferingid: "offering-3"},
+			},
+			want: map[string]string{"vm-1": "offering-1"},
+		},
+		{
+			name:    "detached volumes are skipped",
+			volumes: []*cloudstack.Volume{{Name: "DATA-12", Diskofferingid: "offering-1"}},
+			want:    map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dataDiskOfferingIDs(tt.volumes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dataDiskOfferingIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want string
+	}{
+		{
+			name: "nil tags",
+			want: "{}",
+		},
+		{
+			name: "keys are sorted",
+			tags: map[string]string{"team": "a", "env": "prod"},
+			want: `{"env":"prod","team":"a"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatTags(tt.tags); got != tt.want {
+				t.Errorf("FormatTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want map[string]string
+	}{
+		{
+			name: "missing annotation",
+			s:    "",
+			want: map[string]string{},
+		},
+		{
+			name: "invalid annotation",
+			s:    "team=a",
+			want: map[string]string{},
+		},
+		{
+			name: "tags",
+			s:    `{"env":"prod","team":"a"}`,
+			want: map[string]string{"team": "a", "env": "prod"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseTags(tt.s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRootDiskSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         *int64
+		templateSize int64
+		want         *int64
+	}{
+		{
+			name:         "no size leaves it to the template",
+			templateSize: 8 << 30,
+		},
+		{
+			name:         "size larger than the template",
+			size:         lo.ToPtr[int64](50),
+			templateSize: 8 << 30,
+			want:         lo.ToPtr[int64](50),
+		},
+		{
+			name:         "size smaller than the template",
+			size:         lo.ToPtr[int64](10),
+			templateSize: 20 << 30,
+			want:         lo.ToPtr[int64](20),
+		},
+		{
+			name:         "template size is rounded up to GB",
+			size:         lo.ToPtr[int64](10),
+			templateSize: 20<<30 + 1,
+			want:         lo.ToPtr[int64](21),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rootDiskSize(tt.size, &template.Template{Size: tt.templateSize})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rootDiskSize() = %v, want %v", lo.FromPtr(got), lo.FromPtr(tt.want))
+			}
+		})
+	}
+}
+
+func TestSetsBootOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		template *template.Template
+		want     bool
+	}{
+		{name: "UEFI", template: &template.Template{BootType: v1.BootTypeUEFI, BootMode: "SECURE", Hypervisor: "XenServer"}, want: true},
+		{name: "BIOS on KVM", template: &template.Template{BootType: v1.BootTypeBIOS, BootMode: "LEGACY", Hypervisor: "KVM"}, want: true},
+		{name: "BIOS on VMware", template: &template.Template{BootType: v1.BootTypeBIOS, BootMode: "LEGACY", Hypervisor: "VMware"}, want: true},
+		{name: "BIOS on XenServer", template: &template.Template{BootType: v1.BootTypeBIOS, BootMode: "LEGACY", Hypervisor: "XenServer"}},
+		{name: "BIOS without a hypervisor", template: &template.Template{BootType: v1.BootTypeBIOS, BootMode: "LEGACY"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setsBootOptions(tt.template); got != tt.want {
+				t.Errorf("setsBootOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
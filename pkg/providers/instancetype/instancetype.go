@@ -17,6 +17,9 @@ package instancetype
 import (
 	"context"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/apache/cloudstack-go/v2/cloudstack"
@@ -30,33 +33,65 @@ import (
 
 	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
 	csapi "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudstack"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
 )
 
 // Provider provides instance type information
 type Provider interface {
 	List(ctx context.Context, nodeClass *v1.CloudStackNodeClass) ([]*cloudprovider.InstanceType, error)
 	Get(ctx context.Context, nodeClass *v1.CloudStackNodeClass, name string) (*cloudprovider.InstanceType, error)
+	ResolveServiceOfferings(ctx context.Context, nodeClass *v1.CloudStackNodeClass) ([]*cloudstack.ServiceOffering, error)
+	InvalidateCache()
 }
 
 // DefaultProvider implements the InstanceType Provider
 type DefaultProvider struct {
-	csClient csapi.CloudStackAPI
-	cache    *cache.Cache
-	mu       sync.RWMutex
+	csClient             csapi.CloudStackAPI
+	templateProvider     template.Provider
+	diskOfferingProvider diskoffering.Provider
+	cache                *cache.Cache
+	mu                   sync.RWMutex
 }
 
 // NewDefaultProvider creates a new instance type provider
-func NewDefaultProvider(csClient csapi.CloudStackAPI, cache *cache.Cache) *DefaultProvider {
+func NewDefaultProvider(
+	csClient csapi.CloudStackAPI,
+	templateProvider template.Provider,
+	diskOfferingProvider diskoffering.Provider,
+	cache *cache.Cache,
+) *DefaultProvider {
 	return &DefaultProvider{
-		csClient: csClient,
-		cache:    cache,
+		csClient:             csClient,
+		templateProvider:     templateProvider,
+		diskOfferingProvider: diskOfferingProvider,
+		cache:                cache,
 	}
 }
 
 // List returns all instance types (service offerings)
 func (p *DefaultProvider) List(ctx context.Context, nodeClass *v1.CloudStackNodeClass) ([]*cloudprovider.InstanceType, error) {
 	// Resolve service offerings from node class
-	serviceOfferings, err := p.resolveServiceOfferings(ctx, nodeClass)
+	serviceOfferings, err := p.ResolveServiceOfferings(ctx, nodeClass)
+	if err != nil {
+		return nil, err
+	}
+
+	// The zone is only offered once a matching template is ready there
+	templates, err := p.templateProvider.MatchTemplates(ctx, nodeClass.Spec.TemplateSelectorTerms, nodeClass.Spec.Zone)
+	if err != nil {
+		return nil, fmt.Errorf("matching templates: %w", err)
+	}
+	templates = lo.Filter(templates, func(t *template.Template, _ int) bool {
+		return t.Ready()
+	})
+	if len(templates) == 0 {
+		log.FromContext(ctx).Info("No ready template in zone, marking offerings unavailable", "zone", nodeClass.Spec.Zone)
+	}
+
+	// Resolve ephemeral storage from the disk backing containerd
+	ephemeralStorage, err := p.resolveEphemeralStorage(ctx, nodeClass, templates)
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +99,7 @@ func (p *DefaultProvider) List(ctx context.Context, nodeClass *v1.CloudStackNode
 	// Convert to Karpenter instance types
 	instanceTypes := make([]*cloudprovider.InstanceType, 0, len(serviceOfferings))
 	for _, offering := range serviceOfferings {
-		instanceType := p.convertToInstanceType(offering, nodeClass.Spec.Zone)
+		instanceType := p.convertToInstanceType(ctx, offering, nodeClass.Spec.Zone, len(templates) > 0, ephemeralStorage, nodeClass.Spec.Kubelet)
 		instanceTypes = append(instanceTypes, instanceType)
 	}
 
@@ -91,8 +126,8 @@ func (p *DefaultProvider) Get(ctx context.Context, nodeClass *v1.CloudStackNodeC
 	return instanceType, nil
 }
 
-// resolveServiceOfferings resolves service offerings based on node class selectors
-func (p *DefaultProvider) resolveServiceOfferings(ctx context.Context, nodeClass *v1.CloudStackNodeClass) ([]*cloudstack.ServiceOffering, error) {
+// ResolveServiceOfferings resolves service offerings based on node class selectors
+func (p *DefaultProvider) ResolveServiceOfferings(ctx context.Context, nodeClass *v1.CloudStackNodeClass) ([]*cloudstack.ServiceOffering, error) {
 	// Check cache
 	cacheKey := fmt.Sprintf("service-offerings-%s", nodeClass.Spec.Zone)
 	if cached, found := p.cache.Get(cacheKey); found {
@@ -128,6 +163,11 @@ func (p *DefaultProvider) resolveServiceOfferings(ctx context.Context, nodeClass
 	return filtered, nil
 }
 
+// InvalidateCache drops the cached service offerings, so that they're listed again on next use
+func (p *DefaultProvider) InvalidateCache() {
+	p.cache.Flush()
+}
+
 // filterServiceOfferings filters service offerings based on selector terms
 func (p *DefaultProvider) filterServiceOfferings(offerings []*cloudstack.ServiceOffering, terms []v1.ServiceOfferingSelectorTerm) []*cloudstack.ServiceOffering {
 	var matched []*cloudstack.ServiceOffering
@@ -168,13 +208,59 @@ func (p *DefaultProvider) filterServiceOfferings(offerings []*cloudstack.Service
 	return matched
 }
 
+// resolveEphemeralStorage returns the size of the disk backing /var/lib/containerd.
+// This is the block device mapping designated for containerd if any, the data disk attached at
+// deploy time otherwise, or the root disk when the NodeClass has neither, sized from RootDiskSize
+// or from the first ready template when RootDiskSize isn't set.
+func (p *DefaultProvider) resolveEphemeralStorage(ctx context.Context, nodeClass *v1.CloudStackNodeClass, templates []*template.Template) (*resource.Quantity, error) {
+	if mapping, found := lo.Find(nodeClass.Spec.BlockDeviceMappings, func(m v1.BlockDeviceMapping) bool {
+		return m.Containerd
+	}); found {
+		return p.diskSize(ctx, mapping.DiskOffering, mapping.Size, nodeClass.Spec.Zone)
+	}
+
+	if nodeClass.Spec.DiskOffering != nil {
+		return p.diskSize(ctx, *nodeClass.Spec.DiskOffering, nodeClass.Spec.DataDiskSize, nodeClass.Spec.Zone)
+	}
+
+	if nodeClass.Spec.RootDiskSize != nil {
+		return resource.NewQuantity(*nodeClass.Spec.RootDiskSize*1024*1024*1024, resource.BinarySI), nil // GB to bytes
+	}
+
+	if len(templates) == 0 || templates[0].Size == 0 {
+		return nil, nil
+	}
+
+	return resource.NewQuantity(templates[0].Size, resource.BinarySI), nil
+}
+
+// diskSize returns the size of a data disk, which is the size set in the NodeClass for customized
+// disk offerings or the size of the disk offering otherwise
+func (p *DefaultProvider) diskSize(ctx context.Context, diskOffering string, size *int64, zone string) (*resource.Quantity, error) {
+	if size == nil {
+		offering, err := p.diskOfferingProvider.Resolve(ctx, diskOffering, zone)
+		if err != nil {
+			return nil, fmt.Errorf("resolving containerd disk offering: %w", err)
+		}
+		size = &offering.DiskSize
+	}
+	return resource.NewQuantity(*size*1024*1024*1024, resource.BinarySI), nil // GB to bytes
+}
+
 // convertToInstanceType converts a CloudStack service offering to a Karpenter instance type
-func (p *DefaultProvider) convertToInstanceType(offering *cloudstack.ServiceOffering, zone string) *cloudprovider.InstanceType {
+func (p *DefaultProvider) convertToInstanceType(ctx context.Context, offering *cloudstack.ServiceOffering, zone string, available bool, ephemeralStorage *resource.Quantity, kubelet *v1.KubeletConfiguration) *cloudprovider.InstanceType {
+	if kubelet == nil {
+		kubelet = &v1.KubeletConfiguration{}
+	}
+
 	// Calculate capacity
 	capacity := corev1.ResourceList{
 		corev1.ResourceCPU:    *resource.NewQuantity(int64(offering.Cpunumber), resource.DecimalSI),
 		corev1.ResourceMemory: *resource.NewQuantity(int64(offering.Memory)*1024*1024, resource.BinarySI), // MB to bytes
-		corev1.ResourcePods:   *resource.NewQuantity(110, resource.DecimalSI),                             // Default pod limit
+		corev1.ResourcePods:   *resource.NewQuantity(pods(offering, kubelet), resource.DecimalSI),
+	}
+	if ephemeralStorage != nil {
+		capacity[corev1.ResourceEphemeralStorage] = *ephemeralStorage
 	}
 
 	// Build requirements
@@ -199,7 +285,7 @@ func (p *DefaultProvider) convertToInstanceType(offering *cloudstack.ServiceOffe
 				scheduling.NewRequirement(v1.LabelCapacityType, corev1.NodeSelectorOpIn, v1.CapacityTypeOnDemand),
 			),
 			Price:     calculatePrice(offering), // Simple pricing calculation
-			Available: true,
+			Available: available,
 		},
 	}
 
@@ -209,14 +295,102 @@ func (p *DefaultProvider) convertToInstanceType(offering *cloudstack.ServiceOffe
 		Offerings:    offerings,
 		Capacity:     capacity,
 		Overhead: &cloudprovider.InstanceTypeOverhead{
-			KubeReserved: corev1.ResourceList{
-				corev1.ResourceCPU:    resource.MustParse("100m"),
-				corev1.ResourceMemory: resource.MustParse("256Mi"),
-			},
+			KubeReserved:      kubeReserved(kubelet),
+			SystemReserved:    systemReserved(ctx, capacity, kubelet),
+			EvictionThreshold: evictionThreshold(ctx, capacity, kubelet),
 		},
 	}
 }
 
+// pods returns the pod capacity, which is MaxPods (110 by default) capped by PodsPerCore * cores
+func pods(offering *cloudstack.ServiceOffering, kubelet *v1.KubeletConfiguration) int64 {
+	count := int64(lo.FromPtrOr(kubelet.MaxPods, 110))
+	if kubelet.PodsPerCore != nil && *kubelet.PodsPerCore > 0 {
+		count = min(count, int64(*kubelet.PodsPerCore)*int64(offering.Cpunumber))
+	}
+	return count
+}
+
+// kubeReserved returns the resources reserved for Kubernetes system components,
+// defaulting to 100m CPU and 256Mi memory
+func kubeReserved(kubelet *v1.KubeletConfiguration) corev1.ResourceList {
+	reserved := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("256Mi"),
+	}
+	return lo.Assign(reserved, resourceList(kubelet.KubeReserved))
+}
+
+// systemReserved returns the resources reserved for OS system daemons,
+// defaulting the ephemeral-storage reservation from the operator options
+func systemReserved(ctx context.Context, capacity corev1.ResourceList, kubelet *v1.KubeletConfiguration) corev1.ResourceList {
+	reserved := corev1.ResourceList{}
+	if _, ok := capacity[corev1.ResourceEphemeralStorage]; ok {
+		reserved[corev1.ResourceEphemeralStorage] = options.FromContext(ctx).EphemeralStorageSystemReserved
+	}
+	return lo.Assign(reserved, resourceList(kubelet.SystemReserved))
+}
+
+// evictionThreshold returns the memory and ephemeral-storage eviction thresholds, taking the
+// larger of the hard and soft thresholds. The defaults match the kubelet's own hard thresholds,
+// with the nodefs threshold taken from the operator options.
+func evictionThreshold(ctx context.Context, capacity corev1.ResourceList, kubelet *v1.KubeletConfiguration) corev1.ResourceList {
+	evictionHard := lo.Assign(map[string]string{
+		"memory.available": "100Mi",
+		"nodefs.available": options.FromContext(ctx).EphemeralStorageEvictionThreshold,
+	}, kubelet.EvictionHard)
+
+	threshold := corev1.ResourceList{}
+	for resourceName, signal := range map[corev1.ResourceName]string{
+		corev1.ResourceMemory:           "memory.available",
+		corev1.ResourceEphemeralStorage: "nodefs.available",
+	} {
+		resourceCapacity, ok := capacity[resourceName]
+		if !ok {
+			continue
+		}
+		quantity := thresholdQuantity(resourceCapacity, evictionHard[signal])
+		if soft, ok := kubelet.EvictionSoft[signal]; ok {
+			if softQuantity := thresholdQuantity(resourceCapacity, soft); softQuantity.Cmp(quantity) > 0 {
+				quantity = softQuantity
+			}
+		}
+		threshold[resourceName] = quantity
+	}
+	return threshold
+}
+
+// resourceList converts a map of resource names to quantities into a resource list,
+// skipping quantities that can't be parsed
+func resourceList(resources map[string]string) corev1.ResourceList {
+	list := corev1.ResourceList{}
+	for name, value := range resources {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			continue
+		}
+		list[corev1.ResourceName(name)] = quantity
+	}
+	return list
+}
+
+// thresholdQuantity converts a percentage (e.g. "10%") or quantity (e.g. "2Gi") threshold
+// into a quantity relative to the given capacity
+func thresholdQuantity(capacity resource.Quantity, threshold string) resource.Quantity {
+	if percentage, ok := strings.CutSuffix(threshold, "%"); ok {
+		value, err := strconv.ParseFloat(percentage, 64)
+		if err != nil {
+			return resource.Quantity{}
+		}
+		return *resource.NewQuantity(int64(math.Ceil(float64(capacity.Value())*value/100)), resource.BinarySI)
+	}
+	quantity, err := resource.ParseQuantity(threshold)
+	if err != nil {
+		return resource.Quantity{}
+	}
+	return quantity
+}
+
 // calculatePrice calculates a simple price for the service offering
 // This is a basic implementation - you may want to integrate with actual pricing
 func calculatePrice(offering *cloudstack.ServiceOffering) float64 {
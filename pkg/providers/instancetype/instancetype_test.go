@@ -0,0 +1,133 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instancetype
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+)
+
+// fakeDiskOfferingProvider resolves the given disk offerings by name
+type fakeDiskOfferingProvider struct {
+	diskoffering.Provider
+	offerings map[string]*diskoffering.DiskOffering
+}
+
+func (f *fakeDiskOfferingProvider) Resolve(_ context.Context, nameOrID string, _ string) (*diskoffering.DiskOffering, error) {
+	if offering, ok := f.offerings[nameOrID]; ok {
+		return offering, nil
+	}
+	return nil, fmt.Errorf("disk offering %s not found", nameOrID)
+}
+
+func TestResolveEphemeralStorage(t *testing.T) {
+	p := NewDefaultProvider(nil, nil, &fakeDiskOfferingProvider{offerings: map[string]*diskoffering.DiskOffering{
+		"fixed":  {Name: "fixed", DiskSize: 100},
+		"custom": {Name: "custom", IsCustomized: true},
+	}}, nil)
+	templates := []*template.Template{{ID: "template-1", Size: 8 << 30}}
+	tests := []struct {
+		name      string
+		spec      v1.CloudStackNodeClassSpec
+		templates []*template.Template
+		want      string
+		wantErr   bool
+	}{
+		{
+			name: "block device mapping designated for containerd",
+			spec: v1.CloudStackNodeClassSpec{
+				DiskOffering: lo.ToPtr("fixed"),
+				BlockDeviceMappings: []v1.BlockDeviceMapping{
+					{DiskOffering: "custom", Size: lo.ToPtr[int64](50)},
+					{DiskOffering: "custom", Size: lo.ToPtr[int64](200), Containerd: true},
+				},
+			},
+			templates: templates,
+			want:      "200Gi",
+		},
+		{
+			name: "block device mapping sized from its disk offering",
+			spec: v1.CloudStackNodeClassSpec{
+				BlockDeviceMappings: []v1.BlockDeviceMapping{{DiskOffering: "fixed", Containerd: true}},
+			},
+			templates: templates,
+			want:      "100Gi",
+		},
+		{
+			name: "block device mapping with an unknown disk offering",
+			spec: v1.CloudStackNodeClassSpec{
+				BlockDeviceMappings: []v1.BlockDeviceMapping{{DiskOffering: "missing", Containerd: true}},
+			},
+			templates: templates,
+			wantErr:   true,
+		},
+		{
+			name: "data disk",
+			spec: v1.CloudStackNodeClassSpec{
+				RootDiskSize:        lo.ToPtr[int64](20),
+				DiskOffering:        lo.ToPtr("custom"),
+				DataDiskSize:        lo.ToPtr[int64](150),
+				BlockDeviceMappings: []v1.BlockDeviceMapping{{DiskOffering: "fixed"}},
+			},
+			templates: templates,
+			want:      "150Gi",
+		},
+		{
+			name:      "data disk sized from its disk offering",
+			spec:      v1.CloudStackNodeClassSpec{RootDiskSize: lo.ToPtr[int64](20), DiskOffering: lo.ToPtr("fixed")},
+			templates: templates,
+			want:      "100Gi",
+		},
+		{
+			name:      "root disk",
+			spec:      v1.CloudStackNodeClassSpec{RootDiskSize: lo.ToPtr[int64](20)},
+			templates: templates,
+			want:      "20Gi",
+		},
+		{
+			name:      "root disk sized from the template",
+			templates: templates,
+			want:      "8Gi",
+		},
+		{
+			name: "no ready template",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.resolveEphemeralStorage(context.Background(), &v1.CloudStackNodeClass{Spec: tt.spec}, tt.templates)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveEphemeralStorage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("resolveEphemeralStorage() = %s, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Cmp(resource.MustParse(tt.want)) != 0 {
+				t.Errorf("resolveEphemeralStorage() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
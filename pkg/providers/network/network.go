@@ -31,6 +31,7 @@ import (
 type Provider interface {
 	List(ctx context.Context, zone string) ([]*Network, error)
 	ResolveNetworks(ctx context.Context, terms []v1.NetworkSelectorTerm, zone string) ([]*Network, error)
+	InvalidateCache()
 }
 
 // Network represents a CloudStack network
@@ -184,6 +185,11 @@ func (p *DefaultProvider) ResolveNetworks(ctx context.Context, terms []v1.Networ
 	return matchedNetworks, nil
 }
 
+// InvalidateCache drops the cached networks, so that they're listed again on next use
+func (p *DefaultProvider) InvalidateCache() {
+	p.cache.Flush()
+}
+
 // getNetworkTags fetches tags for a network
 func (p *DefaultProvider) getNetworkTags(ctx context.Context, networkID string) (map[string]string, error) {
 	params := p.csClient.(*csapi.Client).Resourcetags.NewListTagsParams()
@@ -0,0 +1,112 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshkeypair
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csapi "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudstack"
+)
+
+// Provider provides SSH key pair information
+type Provider interface {
+	List(ctx context.Context) ([]*SSHKeyPair, error)
+	Get(ctx context.Context, name string) (*SSHKeyPair, error)
+}
+
+// SSHKeyPair represents a CloudStack SSH key pair
+type SSHKeyPair struct {
+	ID          string
+	Name        string
+	Fingerprint string
+}
+
+// DefaultProvider implements the SSHKeyPair Provider
+type DefaultProvider struct {
+	csClient csapi.CloudStackAPI
+	cache    *cache.Cache
+	mu       sync.RWMutex
+}
+
+// NewDefaultProvider creates a new SSH key pair provider
+func NewDefaultProvider(csClient csapi.CloudStackAPI, cache *cache.Cache) *DefaultProvider {
+	return &DefaultProvider{
+		csClient: csClient,
+		cache:    cache,
+	}
+}
+
+// List returns the SSH key pairs of the account
+func (p *DefaultProvider) List(ctx context.Context) ([]*SSHKeyPair, error) {
+	// Check cache first
+	if cached, found := p.cache.Get("sshkeypairs"); found {
+		return cached.([]*SSHKeyPair), nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Double-check after acquiring lock
+	if cached, found := p.cache.Get("sshkeypairs"); found {
+		return cached.([]*SSHKeyPair), nil
+	}
+
+	params := p.csClient.(*csapi.Client).SSH.NewListSSHKeyPairsParams()
+
+	resp, err := p.csClient.ListSSHKeyPairs(params)
+	if err != nil {
+		return nil, fmt.Errorf("listing SSH key pairs: %w", err)
+	}
+
+	keyPairs := make([]*SSHKeyPair, 0, len(resp.SSHKeyPairs))
+	for _, csKeyPair := range resp.SSHKeyPairs {
+		keyPairs = append(keyPairs, &SSHKeyPair{
+			ID:          csKeyPair.Id,
+			Name:        csKeyPair.Name,
+			Fingerprint: csKeyPair.Fingerprint,
+		})
+	}
+
+	// Cache the results
+	p.cache.Set("sshkeypairs", keyPairs, cache.DefaultExpiration)
+
+	log.FromContext(ctx).Info("Listed SSH key pairs", "count", len(keyPairs))
+
+	return keyPairs, nil
+}
+
+// Get returns an SSH key pair by name
+func (p *DefaultProvider) Get(ctx context.Context, name string) (*SSHKeyPair, error) {
+	keyPairs, err := p.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPair, found := lo.Find(keyPairs, func(k *SSHKeyPair) bool {
+		return k.Name == name
+	})
+
+	if !found {
+		return nil, fmt.Errorf("SSH key pair %s not found", name)
+	}
+
+	return keyPair, nil
+}
@@ -17,50 +17,80 @@ package template
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/patrickmn/go-cache"
 	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/util/version"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
 	csapi "github.com/mperea/karpenter-provider-cloudstack/pkg/cloudstack"
+	versionprovider "github.com/mperea/karpenter-provider-cloudstack/pkg/providers/version"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
 )
 
 // Provider provides template information
 type Provider interface {
 	List(ctx context.Context, zone string) ([]*Template, error)
+	MatchTemplates(ctx context.Context, terms []v1.TemplateSelectorTerm, zone string) ([]*Template, error)
 	ResolveTemplates(ctx context.Context, terms []v1.TemplateSelectorTerm, zone string) ([]*Template, error)
+	InvalidateCache()
 }
 
+const (
+	bootModeLegacy = "LEGACY"
+)
+
 // Template represents a CloudStack template
 type Template struct {
-	ID          string
-	Name        string
-	DisplayText string
-	Zone        string
-	ZoneID      string
-	OSType      string
-	OSTypeName  string
-	Status      string
-	IsReady     bool
-	IsPublic    bool
-	IsFeatured  bool
-	Tags        map[string]string
+	ID              string
+	Name            string
+	DisplayText     string
+	Zone            string
+	ZoneID          string
+	OSType          string
+	OSTypeName      string
+	Size            int64 // in bytes
+	Status          string
+	IsReady         bool
+	IsPublic        bool
+	IsFeatured      bool
+	Tags            map[string]string
+	Created         time.Time
+	Hypervisor      string
+	BootType        string // BIOS or UEFI
+	BootMode        string // LEGACY or SECURE
+	PasswordEnabled bool
+	SSHKeyEnabled   bool
+	// Version is the value of the selector term VersionTag, set on resolved templates
+	Version string
+}
+
+// Ready returns whether the template is downloaded and can be deployed in its zone
+func (t *Template) Ready() bool {
+	return t.IsReady && t.Status == "Download Complete"
 }
 
 // DefaultProvider implements the Template Provider
 type DefaultProvider struct {
-	csClient csapi.CloudStackAPI
-	cache    *cache.Cache
-	mu       sync.RWMutex
+	csClient        csapi.CloudStackAPI
+	zoneProvider    zone.Provider
+	versionProvider versionprovider.Provider
+	cache           *cache.Cache
+	mu              sync.RWMutex
 }
 
 // NewDefaultProvider creates a new template provider
-func NewDefaultProvider(csClient csapi.CloudStackAPI, cache *cache.Cache) *DefaultProvider {
+func NewDefaultProvider(csClient csapi.CloudStackAPI, zoneProvider zone.Provider, versionProvider versionprovider.Provider, cache *cache.Cache) *DefaultProvider {
 	return &DefaultProvider{
-		csClient: csClient,
-		cache:    cache,
+		csClient:        csClient,
+		zoneProvider:    zoneProvider,
+		versionProvider: versionProvider,
+		cache:           cache,
 	}
 }
 
@@ -109,20 +139,36 @@ func (p *DefaultProvider) List(ctx context.Context, zone string) ([]*Template, e
 				log.FromContext(ctx).V(1).Info("Failed to get tags for template", "template", csTemplate.Id, "error", err)
 				tags = make(map[string]string)
 			}
+			created, err := time.Parse(csapi.TimeLayout, csTemplate.Created)
+			if err != nil {
+				log.FromContext(ctx).V(1).Info("Failed to parse template creation time", "template", csTemplate.Id, "created", csTemplate.Created)
+			}
 
 			template := &Template{
-				ID:          csTemplate.Id,
-				Name:        csTemplate.Name,
-				DisplayText: csTemplate.Displaytext,
-				Zone:        csTemplate.Zonename,
-				ZoneID:      csTemplate.Zoneid,
-				OSType:      csTemplate.Ostypeid,
-				OSTypeName:  csTemplate.Ostypename,
-				Status:      csTemplate.Status,
-				IsReady:     csTemplate.Isready,
-				IsPublic:    csTemplate.Ispublic,
-				IsFeatured:  csTemplate.Isfeatured,
-				Tags:        tags,
+				ID:              csTemplate.Id,
+				Name:            csTemplate.Name,
+				DisplayText:     csTemplate.Displaytext,
+				Zone:            csTemplate.Zonename,
+				ZoneID:          csTemplate.Zoneid,
+				OSType:          csTemplate.Ostypeid,
+				OSTypeName:      csTemplate.Ostypename,
+				Size:            csTemplate.Size,
+				Status:          csTemplate.Status,
+				IsReady:         csTemplate.Isready,
+				IsPublic:        csTemplate.Ispublic,
+				IsFeatured:      csTemplate.Isfeatured,
+				Tags:            tags,
+				Created:         created,
+				Hypervisor:      csTemplate.Hypervisor,
+				BootType:        v1.BootTypeBIOS,
+				BootMode:        bootModeLegacy,
+				PasswordEnabled: csTemplate.Passwordenabled,
+				SSHKeyEnabled:   csTemplate.Sshkeyenabled,
+			}
+			// UEFI templates carry their boot mode in the UEFI detail
+			if bootMode, ok := csTemplate.Details["UEFI"]; ok {
+				template.BootType = v1.BootTypeUEFI
+				template.BootMode = strings.ToUpper(bootMode)
 			}
 			allTemplates = append(allTemplates, template)
 		}
@@ -141,8 +187,35 @@ func (p *DefaultProvider) List(ctx context.Context, zone string) ([]*Template, e
 	return allTemplates, nil
 }
 
-// ResolveTemplates resolves templates based on selector terms
+// ResolveTemplates resolves the templates ready in a zone based on selector terms. The templates are
+// ordered newest first, by the version held in the VersionTag of the term that matched them when set
+// and by creation time otherwise, so that the first template is the one new nodes launch with.
 func (p *DefaultProvider) ResolveTemplates(ctx context.Context, terms []v1.TemplateSelectorTerm, zone string) ([]*Template, error) {
+	matchedTemplates, err := p.MatchTemplates(ctx, terms, zone)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchedTemplates) == 0 {
+		return nil, fmt.Errorf("no templates matched the selector terms in zone %s", zone)
+	}
+
+	// Filter only ready templates
+	readyTemplates := lo.Filter(matchedTemplates, func(t *Template, _ int) bool {
+		return t.Ready()
+	})
+	if len(readyTemplates) == 0 {
+		return nil, fmt.Errorf("none of the %d templates matching the selector terms are ready in zone %s", len(matchedTemplates), zone)
+	}
+
+	log.FromContext(ctx).Info("Resolved templates", "zone", zone, "count", len(readyTemplates))
+
+	return readyTemplates, nil
+}
+
+// MatchTemplates returns the templates matching the selector terms in a zone, including the ones still
+// being downloaded or copied into the zone. Templates are per-zone copies, so a template ready in one
+// zone may not be ready in another. The ready templates are ordered first, in ResolveTemplates order.
+func (p *DefaultProvider) MatchTemplates(ctx context.Context, terms []v1.TemplateSelectorTerm, zone string) ([]*Template, error) {
 	allTemplates, err := p.List(ctx, zone)
 	if err != nil {
 		return nil, err
@@ -151,15 +224,16 @@ func (p *DefaultProvider) ResolveTemplates(ctx context.Context, terms []v1.Templ
 	var matchedTemplates []*Template
 
 	for _, term := range terms {
-		// Match by ID (highest priority)
+		// Match by ID (highest priority). A term pinning an ID missing from the zone matches nothing,
+		// rather than every template passing its other filters.
 		if term.ID != "" {
 			template, found := lo.Find(allTemplates, func(t *Template) bool {
 				return t.ID == term.ID
 			})
 			if found {
-				matchedTemplates = append(matchedTemplates, template)
-				continue
+				matchedTemplates = append(matchedTemplates, withVersion(template, term.VersionTag))
 			}
+			continue
 		}
 
 		// Match by Name
@@ -168,50 +242,141 @@ func (p *DefaultProvider) ResolveTemplates(ctx context.Context, terms []v1.Templ
 				return t.Name == term.Name
 			})
 			if found {
-				matchedTemplates = append(matchedTemplates, template)
+				matchedTemplates = append(matchedTemplates, withVersion(template, term.VersionTag))
 				continue
 			}
 		}
 
-		// Filter by OSType if specified
-		templates := allTemplates
-		if term.OSType != "" {
-			templates = lo.Filter(templates, func(t *Template, _ int) bool {
-				return t.OSTypeName == term.OSType || t.OSType == term.OSType
-			})
-		}
+		// Filter by OSType, hypervisor, boot type and password and SSH key support if specified
+		templates := lo.Filter(allTemplates, func(t *Template, _ int) bool {
+			return matchesFilters(t, term)
+		})
 
 		// Match by Tags
 		if len(term.Tags) > 0 {
-			matches := lo.Filter(templates, func(t *Template, _ int) bool {
+			templates = lo.Filter(templates, func(t *Template, _ int) bool {
 				return matchesTags(t.Tags, term.Tags)
 			})
-			matchedTemplates = append(matchedTemplates, matches...)
-		} else if term.OSType != "" {
-			// If only OSType is specified, add all matching templates
-			matchedTemplates = append(matchedTemplates, templates...)
+		} else if term.OSType == "" && term.KubernetesVersion == nil {
+			continue
+		}
+
+		// Match by Kubernetes version
+		if term.KubernetesVersion != nil {
+			kubernetesVersion, err := p.versionProvider.Get(ctx)
+			if err != nil {
+				return nil, err
+			}
+			templates = lo.Filter(templates, func(t *Template, _ int) bool {
+				return matchesKubernetesVersion(t.Tags[term.KubernetesVersion.Tag], kubernetesVersion)
+			})
+		}
+
+		// Add all templates matching the filters
+		for _, template := range templates {
+			matchedTemplates = append(matchedTemplates, withVersion(template, term.VersionTag))
 		}
 	}
 
-	// Remove duplicates
+	// Remove duplicates, keeping the version of the first term matching a template
 	matchedTemplates = lo.UniqBy(matchedTemplates, func(t *Template) string {
 		return t.ID
 	})
 
-	// Filter only ready templates
-	matchedTemplates = lo.Filter(matchedTemplates, func(t *Template, _ int) bool {
-		return t.IsReady && t.Status == "Download Complete"
-	})
-
 	if len(matchedTemplates) == 0 {
-		return nil, fmt.Errorf("no templates matched the selector terms in zone %s", zone)
+		return nil, nil
+	}
+
+	// Filter the templates the zone clusters can run. Templates built for another hypervisor
+	// fail to deploy in mixed-hypervisor zones.
+	hypervisors, err := p.zoneProvider.Hypervisors(ctx, zone)
+	if err != nil {
+		return nil, err
 	}
+	unsupported := lo.Filter(matchedTemplates, func(t *Template, _ int) bool {
+		return t.Hypervisor != "" && !lo.ContainsBy(hypervisors, func(h string) bool { return strings.EqualFold(h, t.Hypervisor) })
+	})
+	if len(unsupported) == len(matchedTemplates) {
+		return nil, fmt.Errorf("no templates matched the selector terms for the hypervisors of zone %s %v, found templates for %v",
+			zone, hypervisors, lo.Uniq(lo.Map(unsupported, func(t *Template, _ int) string { return t.Hypervisor })))
+	}
+	matchedTemplates, _ = lo.Difference(matchedTemplates, unsupported)
 
-	log.FromContext(ctx).Info("Resolved templates", "zone", zone, "count", len(matchedTemplates))
+	slices.SortStableFunc(matchedTemplates, func(a, b *Template) int {
+		if a.Ready() != b.Ready() {
+			return lo.Ternary(a.Ready(), -1, 1)
+		}
+		return compareTemplates(a, b)
+	})
 
 	return matchedTemplates, nil
 }
 
+// withVersion returns a copy of a listed template with its version read from the given tag, leaving
+// the cached template untouched
+func withVersion(template *Template, versionTag string) *Template {
+	t := *template
+	if versionTag != "" {
+		if _, err := parseVersion(t.Tags[versionTag]); err == nil {
+			t.Version = t.Tags[versionTag]
+		}
+	}
+	return &t
+}
+
+// compareTemplates orders templates newest first: versioned templates by descending version, then
+// the others by descending creation time. Ties are broken by name and ID so the order is stable
+// across API calls.
+func compareTemplates(a, b *Template) int {
+	if a.Version != "" && b.Version != "" {
+		va, vb := lo.Must(parseVersion(a.Version)), lo.Must(parseVersion(b.Version))
+		if va.GreaterThan(vb) {
+			return -1
+		}
+		if va.LessThan(vb) {
+			return 1
+		}
+	} else if a.Version != "" {
+		return -1
+	} else if b.Version != "" {
+		return 1
+	}
+	if c := b.Created.Compare(a.Created); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// matchesKubernetesVersion checks if a template Kubernetes version has the major and minor versions
+// of the API server
+func matchesKubernetesVersion(templateVersion, kubernetesVersion string) bool {
+	tv, err := version.ParseGeneric(templateVersion)
+	if err != nil {
+		return false
+	}
+	kv, err := version.ParseGeneric(kubernetesVersion)
+	if err != nil {
+		return false
+	}
+	return tv.Major() == kv.Major() && tv.Minor() == kv.Minor()
+}
+
+// parseVersion parses a semantic version, falling back to a generic "major.minor" version
+func parseVersion(s string) (*version.Version, error) {
+	if v, err := version.ParseSemantic(s); err == nil {
+		return v, nil
+	}
+	return version.ParseGeneric(s)
+}
+
+// InvalidateCache drops the cached templates, so that they're listed again on next use
+func (p *DefaultProvider) InvalidateCache() {
+	p.cache.Flush()
+}
+
 // getTemplateTags fetches tags for a template
 func (p *DefaultProvider) getTemplateTags(ctx context.Context, templateID string) (map[string]string, error) {
 	params := p.csClient.(*csapi.Client).Resourcetags.NewListTagsParams()
@@ -231,6 +396,27 @@ func (p *DefaultProvider) getTemplateTags(ctx context.Context, templateID string
 	return tags, nil
 }
 
+// matchesFilters checks if a template matches the OSType, hypervisor, boot type and password and SSH key
+// support filters of a selector term
+func matchesFilters(t *Template, term v1.TemplateSelectorTerm) bool {
+	if term.OSType != "" && t.OSTypeName != term.OSType && t.OSType != term.OSType {
+		return false
+	}
+	if term.Hypervisor != "" && !strings.EqualFold(t.Hypervisor, term.Hypervisor) {
+		return false
+	}
+	if term.BootType != "" && t.BootType != term.BootType {
+		return false
+	}
+	if term.PasswordEnabled != nil && t.PasswordEnabled != *term.PasswordEnabled {
+		return false
+	}
+	if term.SSHKeyEnabled != nil && t.SSHKeyEnabled != *term.SSHKeyEnabled {
+		return false
+	}
+	return true
+}
+
 // matchesTags checks if resource tags match selector tags
 // Supports wildcard matching with '*'
 func matchesTags(resourceTags, selectorTags map[string]string) bool {
@@ -0,0 +1,327 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	versionprovider "github.com/mperea/karpenter-provider-cloudstack/pkg/providers/version"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
+)
+
+const testZone = "zone-1"
+
+// fakeZoneProvider returns the hypervisors of a zone
+type fakeZoneProvider struct {
+	zone.Provider
+	hypervisors []string
+}
+
+func (f *fakeZoneProvider) Hypervisors(context.Context, string) ([]string, error) {
+	return f.hypervisors, nil
+}
+
+// fakeVersionProvider returns the Kubernetes version of the API server
+type fakeVersionProvider struct {
+	versionprovider.Provider
+	version string
+}
+
+func (f *fakeVersionProvider) Get(context.Context) (string, error) {
+	return f.version, nil
+}
+
+// newTestProvider returns a provider listing the given templates in testZone
+func newTestProvider(templates []*Template, hypervisors []string, kubernetesVersion string) *DefaultProvider {
+	c := cache.New(time.Hour, time.Hour)
+	c.SetDefault("templates-"+testZone, templates)
+	return NewDefaultProvider(nil, &fakeZoneProvider{hypervisors: hypervisors}, &fakeVersionProvider{version: kubernetesVersion}, c)
+}
+
+func readyTemplate(id string, created time.Time, tags map[string]string) *Template {
+	return &Template{
+		ID:         id,
+		Name:       id,
+		Status:     "Download Complete",
+		IsReady:    true,
+		Created:    created,
+		Tags:       tags,
+		Hypervisor: "KVM",
+		BootType:   v1.BootTypeBIOS,
+	}
+}
+
+func TestCompareTemplates(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		a, b *Template
+		want int
+	}{
+		{
+			name: "higher version first",
+			a:    &Template{Version: "1.31.2", Created: now.Add(-time.Hour)},
+			b:    &Template{Version: "1.31.0", Created: now},
+			want: -1,
+		},
+		{
+			name: "versions compare semantically",
+			a:    &Template{Version: "v1.9.0"},
+			b:    &Template{Version: "v1.10.0"},
+			want: 1,
+		},
+		{
+			name: "versioned templates before unversioned ones",
+			a:    &Template{Created: now},
+			b:    &Template{Version: "1.0.0", Created: now.Add(-time.Hour)},
+			want: 1,
+		},
+		{
+			name: "newer template first",
+			a:    &Template{Created: now},
+			b:    &Template{Created: now.Add(-time.Hour)},
+			want: -1,
+		},
+		{
+			name: "equal versions fall back to creation time",
+			a:    &Template{Version: "1.31", Created: now.Add(-time.Hour)},
+			b:    &Template{Version: "1.31", Created: now},
+			want: 1,
+		},
+		{
+			name: "ties broken by name",
+			a:    &Template{Name: "a", ID: "2", Created: now},
+			b:    &Template{Name: "b", ID: "1", Created: now},
+			want: -1,
+		},
+		{
+			name: "ties broken by ID",
+			a:    &Template{Name: "a", ID: "2", Created: now},
+			b:    &Template{Name: "a", ID: "1", Created: now},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareTemplates(tt.a, tt.b); got != tt.want {
+				t.Errorf("compareTemplates() = %d, want %d", got, tt.want)
+			}
+			if got := compareTemplates(tt.b, tt.a); got != -tt.want {
+				t.Errorf("compareTemplates() reversed = %d, want %d", got, -tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTemplates(t *testing.T) {
+	now := time.Now()
+	templates := []*Template{
+		readyTemplate("old", now.Add(-2*time.Hour), map[string]string{"os": "ubuntu", "version": "1.0.0", "kubernetes-version": "1.30"}),
+		readyTemplate("new", now.Add(-time.Hour), map[string]string{"os": "ubuntu", "version": "0.9.0", "kubernetes-version": "1.31"}),
+		readyTemplate("newest", now, map[string]string{"os": "ubuntu", "kubernetes-version": "1.31.2"}),
+		{ID: "downloading", Name: "downloading", Status: "Downloading", Created: now.Add(time.Hour), Tags: map[string]string{"os": "ubuntu"}, Hypervisor: "KVM"},
+		readyTemplate("other", now, map[string]string{"os": "debian"}),
+	}
+	templates[4].OSTypeName = "Debian GNU/Linux 12 (64-bit)"
+	vmware := readyTemplate("vmware", now.Add(2*time.Hour), nil)
+	vmware.Hypervisor = "VMware"
+
+	tests := []struct {
+		name        string
+		terms       []v1.TemplateSelectorTerm
+		hypervisors []string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name:  "tags select templates ready first, newest first",
+			terms: []v1.TemplateSelectorTerm{{Tags: map[string]string{"os": "ubuntu"}}},
+			want:  []string{"newest", "new", "old", "downloading"},
+		},
+		{
+			name:  "wildcard tags",
+			terms: []v1.TemplateSelectorTerm{{Tags: map[string]string{"version": "*"}}},
+			want:  []string{"new", "old"},
+		},
+		{
+			name:  "version tag orders by version",
+			terms: []v1.TemplateSelectorTerm{{Tags: map[string]string{"os": "ubuntu"}, VersionTag: "version"}},
+			want:  []string{"old", "new", "newest", "downloading"},
+		},
+		{
+			name:  "ID",
+			terms: []v1.TemplateSelectorTerm{{ID: "old"}},
+			want:  []string{"old"},
+		},
+		{
+			name:  "ID missing from the zone matches nothing",
+			terms: []v1.TemplateSelectorTerm{{ID: "missing", Tags: map[string]string{"os": "ubuntu"}, OSType: "Debian GNU/Linux 12 (64-bit)"}},
+		},
+		{
+			name:  "name",
+			terms: []v1.TemplateSelectorTerm{{Name: "new"}},
+			want:  []string{"new"},
+		},
+		{
+			name:  "OS type",
+			terms: []v1.TemplateSelectorTerm{{OSType: "Debian GNU/Linux 12 (64-bit)"}},
+			want:  []string{"other"},
+		},
+		{
+			name:  "Kubernetes version",
+			terms: []v1.TemplateSelectorTerm{{Tags: map[string]string{"os": "ubuntu"}, KubernetesVersion: &v1.KubernetesVersionSelector{Tag: "kubernetes-version"}}},
+			want:  []string{"newest", "new"},
+		},
+		{
+			name:  "terms are merged without duplicates",
+			terms: []v1.TemplateSelectorTerm{{ID: "old"}, {Tags: map[string]string{"version": "*"}}},
+			want:  []string{"new", "old"},
+		},
+		{
+			name:  "term without filters matches nothing",
+			terms: []v1.TemplateSelectorTerm{{VersionTag: "version"}},
+		},
+		{
+			name:  "unmatched tags",
+			terms: []v1.TemplateSelectorTerm{{Tags: map[string]string{"os": "windows"}}},
+		},
+		{
+			name:        "templates for other hypervisors are left out",
+			terms:       []v1.TemplateSelectorTerm{{Name: "vmware"}, {Name: "old"}},
+			hypervisors: []string{"KVM"},
+			want:        []string{"old"},
+		},
+		{
+			name:        "templates for other hypervisors only",
+			terms:       []v1.TemplateSelectorTerm{{Name: "vmware"}},
+			hypervisors: []string{"KVM"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(append(slices.Clone(templates), vmware), lo.Ternary(tt.hypervisors != nil, tt.hypervisors, []string{"KVM", "VMware"}), "v1.31.4")
+			matched, err := p.MatchTemplates(context.Background(), tt.terms, testZone)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MatchTemplates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := lo.Map(matched, func(t *Template, _ int) string { return t.ID }); !slices.Equal(got, tt.want) {
+				t.Errorf("MatchTemplates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTemplates(t *testing.T) {
+	now := time.Now()
+	downloading := &Template{ID: "downloading", Name: "downloading", Status: "Downloading", Tags: map[string]string{"os": "ubuntu"}}
+	tests := []struct {
+		name      string
+		templates []*Template
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "ready templates",
+			templates: []*Template{downloading, readyTemplate("ready", now, map[string]string{"os": "ubuntu"})},
+			want:      []string{"ready"},
+		},
+		{
+			name:      "no ready templates",
+			templates: []*Template{downloading},
+			wantErr:   true,
+		},
+		{
+			name:    "no templates",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(tt.templates, []string{"KVM"}, "")
+			resolved, err := p.ResolveTemplates(context.Background(), []v1.TemplateSelectorTerm{{Tags: map[string]string{"os": "ubuntu"}}}, testZone)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveTemplates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := lo.Map(resolved, func(t *Template, _ int) string { return t.ID }); !slices.Equal(got, tt.want) {
+				t.Errorf("ResolveTemplates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesFilters(t *testing.T) {
+	template := &Template{
+		OSType:          "os-1",
+		OSTypeName:      "Ubuntu 24.04 LTS",
+		Hypervisor:      "KVM",
+		BootType:        v1.BootTypeUEFI,
+		PasswordEnabled: false,
+		SSHKeyEnabled:   true,
+	}
+	tests := []struct {
+		name string
+		term v1.TemplateSelectorTerm
+		want bool
+	}{
+		{name: "no filters", want: true},
+		{name: "OS type name", term: v1.TemplateSelectorTerm{OSType: "Ubuntu 24.04 LTS"}, want: true},
+		{name: "OS type ID", term: v1.TemplateSelectorTerm{OSType: "os-1"}, want: true},
+		{name: "other OS type", term: v1.TemplateSelectorTerm{OSType: "os-2"}},
+		{name: "hypervisor is case insensitive", term: v1.TemplateSelectorTerm{Hypervisor: "kvm"}, want: true},
+		{name: "other hypervisor", term: v1.TemplateSelectorTerm{Hypervisor: "VMware"}},
+		{name: "boot type", term: v1.TemplateSelectorTerm{BootType: v1.BootTypeUEFI}, want: true},
+		{name: "other boot type", term: v1.TemplateSelectorTerm{BootType: v1.BootTypeBIOS}},
+		{name: "password disabled", term: v1.TemplateSelectorTerm{PasswordEnabled: lo.ToPtr(false)}, want: true},
+		{name: "password enabled", term: v1.TemplateSelectorTerm{PasswordEnabled: lo.ToPtr(true)}},
+		{name: "SSH key enabled", term: v1.TemplateSelectorTerm{SSHKeyEnabled: lo.ToPtr(true)}, want: true},
+		{name: "SSH key disabled", term: v1.TemplateSelectorTerm{SSHKeyEnabled: lo.ToPtr(false)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesFilters(template, tt.term); got != tt.want {
+				t.Errorf("matchesFilters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesKubernetesVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		templateVersion string
+		want            bool
+	}{
+		{name: "major and minor", templateVersion: "1.31", want: true},
+		{name: "patch is ignored", templateVersion: "v1.31.0", want: true},
+		{name: "other minor", templateVersion: "1.30.6"},
+		{name: "missing tag", templateVersion: ""},
+		{name: "invalid tag", templateVersion: "latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesKubernetesVersion(tt.templateVersion, "v1.31.4"); got != tt.want {
+				t.Errorf("matchesKubernetesVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
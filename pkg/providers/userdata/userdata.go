@@ -0,0 +1,676 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/yaml"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/bootstraptoken"
+)
+
+const (
+	// KubeletConfigPath is the kubelet drop-in configuration file written on the node.
+	// The kubelet must be started with --config-dir pointing to its directory.
+	KubeletConfigPath = "/etc/kubernetes/kubelet.conf.d/40-karpenter.conf"
+
+	mimeBoundary = "//"
+
+	// cloudConfigMergeType appends lists and merges dicts of the cloud-config parts,
+	// so that the generated part doesn't replace the write_files or runcmd of the user's part
+	cloudConfigMergeType = "list(append)+dict(recurse_array)+str()"
+)
+
+// Part is a single part of a MIME multipart user data document
+type Part struct {
+	ContentType string
+	Content     string
+}
+
+// Provider provides the user data of the nodes
+type Provider interface {
+	Resolve(ctx context.Context, nodeClass *v1.CloudStackNodeClass) (string, error)
+	Generate(ctx context.Context, nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim, instanceType string) (string, error)
+	Validate(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error
+}
+
+// DefaultProvider implements the UserData Provider
+type DefaultProvider struct {
+	apiReader              client.Reader
+	bootstrapTokenProvider bootstraptoken.Provider
+}
+
+// NewDefaultProvider creates a new user data provider. The referenced Secrets and ConfigMaps are read
+// through the API reader so that the manager doesn't start cluster-wide Secret and ConfigMap informers.
+func NewDefaultProvider(apiReader client.Reader, bootstrapTokenProvider bootstraptoken.Provider) *DefaultProvider {
+	return &DefaultProvider{
+		apiReader:              apiReader,
+		bootstrapTokenProvider: bootstrapTokenProvider,
+	}
+}
+
+// Resolve returns the NodeClass user data, either inline or read from the Secret or ConfigMap key it references
+func (p *DefaultProvider) Resolve(ctx context.Context, nodeClass *v1.CloudStackNodeClass) (string, error) {
+	source := nodeClass.Spec.UserDataFrom
+	if source == nil {
+		return lo.FromPtr(nodeClass.Spec.UserData), nil
+	}
+
+	if ref := source.SecretKeyRef; ref != nil {
+		secret := &corev1.Secret{}
+		if err := p.apiReader.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+			return "", fmt.Errorf("getting secret %s/%s: %w", ref.Namespace, ref.Name, err)
+		}
+		data, ok := secret.Data[ref.Key]
+		if !ok {
+			return "", fmt.Errorf("key %s not found in secret %s/%s", ref.Key, ref.Namespace, ref.Name)
+		}
+		return string(data), nil
+	}
+
+	ref := source.ConfigMapKeyRef
+	configMap := &corev1.ConfigMap{}
+	if err := p.apiReader.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, configMap); err != nil {
+		return "", fmt.Errorf("getting configmap %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	data, ok := configMap.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in configmap %s/%s", ref.Key, ref.Namespace, ref.Name)
+	}
+	return data, nil
+}
+
+// Generate returns the user data for the node launched for a NodeClaim.
+// With the Custom bootstrap mode, the NodeClass user data is returned as is, preceded by a
+// kubelet drop-in configuration part when the NodeClass has a kubelet configuration.
+// Other bootstrap modes generate a cloud-config part joining the node to the cluster, followed by
+// the NodeClass user data. Multiple parts are returned as a MIME multipart document.
+func (p *DefaultProvider) Generate(ctx context.Context, nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim, instanceType string) (string, error) {
+	rawUserData, err := p.Resolve(ctx, nodeClass)
+	if err != nil {
+		return "", err
+	}
+	token, err := p.bootstrapTokenProvider.Token(ctx, nodeClass, nodeClaim)
+	if err != nil {
+		return "", fmt.Errorf("getting bootstrap token: %w", err)
+	}
+	return generate(ctx, rawUserData, nodeClass, nodeClaim, instanceType, token)
+}
+
+// generate renders the user data for a NodeClaim and merges it with the bootstrap or kubelet part
+// of the NodeClass bootstrap mode
+func generate(ctx context.Context, rawUserData string, nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim, instanceType string, token string) (string, error) {
+	userData, err := render(ctx, rawUserData, nodeClass, nodeClaim, instanceType, token)
+	if err != nil {
+		return "", fmt.Errorf("rendering user data: %w", err)
+	}
+
+	var part Part
+	switch mode := lo.FromPtrOr(nodeClass.Spec.BootstrapMode, v1.BootstrapModeCustom); mode {
+	case v1.BootstrapModeCustom:
+		if nodeClass.Spec.Kubelet == nil {
+			return userData, nil
+		}
+		part, err = KubeletConfigPart(nodeClass.Spec.Kubelet)
+	default:
+		part, err = bootstrapPart(ctx, mode, nodeClass, nodeClaim, token)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	userParts, err := Parts(userData)
+	if err != nil {
+		return "", fmt.Errorf("parsing user data: %w", err)
+	}
+	return Merge(append([]Part{part}, userParts...))
+}
+
+// Validate checks that the NodeClass user data resolves to a valid template, that the cluster join
+// parameters required by the NodeClass bootstrap mode are set, and that the user data generated for
+// nodes fits the CloudStack user data limit
+func (p *DefaultProvider) Validate(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	rawUserData, err := p.Resolve(ctx, nodeClass)
+	if err != nil {
+		return err
+	}
+	if _, err := parse(rawUserData); err != nil {
+		return fmt.Errorf("parsing user data template: %w", err)
+	}
+	if err := validateBootstrap(ctx, nodeClass); err != nil {
+		return err
+	}
+
+	// The size is checked on the user data generated for a NodeClaim without labels, with a token of
+	// the bootstrap token length when the token is minted at launch
+	var token string
+	if bootstrap := nodeClass.Spec.Bootstrap; bootstrap != nil {
+		token = lo.Ternary(bootstrap.TokenScope != nil, validationToken, lo.FromPtr(bootstrap.Token))
+	}
+	userData, err := generate(ctx, rawUserData, nodeClass, &karpv1.NodeClaim{ObjectMeta: metav1.ObjectMeta{Name: nodeClass.Name}}, "", token)
+	if err != nil {
+		return fmt.Errorf("generating user data: %w", err)
+	}
+	_, err = Encode(ctx, nodeClass, userData)
+	return err
+}
+
+// validationToken stands in for the bootstrap tokens minted at launch when validating the user data
+const validationToken = "abcdef.0123456789abcdef"
+
+// ErrTooLarge is returned when the encoded user data exceeds the CloudStack user data limit
+var ErrTooLarge = errors.New("user data too large")
+
+// Encode base64-encodes the user data as CloudStack expects it, gzip-compressing it first when
+// the NodeClass asks for it, and checks it against the CloudStack user data limit
+func Encode(ctx context.Context, nodeClass *v1.CloudStackNodeClass, userData string) (string, error) {
+	data := []byte(userData)
+	if lo.FromPtr(nodeClass.Spec.UserDataCompression) == v1.UserDataCompressionGzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return "", fmt.Errorf("compressing user data: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return "", fmt.Errorf("compressing user data: %w", err)
+		}
+		data = buf.Bytes()
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if maxLength := options.FromContext(ctx).UserDataMaxLength; len(encoded) > maxLength {
+		return "", fmt.Errorf("%w: %d bytes once encoded, exceeding the %d bytes limit set by vm.userdata.max.length, "+
+			"use userDataCompression: Gzip or reduce the user data", ErrTooLarge, len(encoded), maxLength)
+	}
+	return encoded, nil
+}
+
+// Hash returns the hash of user data content
+func Hash(userData string) string {
+	sum := sha256.Sum256([]byte(userData))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateBootstrap checks that the cluster join parameters required by the NodeClass bootstrap mode are set
+func validateBootstrap(ctx context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	mode := lo.FromPtrOr(nodeClass.Spec.BootstrapMode, v1.BootstrapModeCustom)
+	if mode == v1.BootstrapModeCustom {
+		return nil
+	}
+	if serverURL(ctx, nodeClass) == "" {
+		return fmt.Errorf("bootstrap mode %s requires bootstrap.serverURL or the CLUSTER_ENDPOINT option", mode)
+	}
+	if nodeClass.Spec.Bootstrap == nil || (lo.FromPtr(nodeClass.Spec.Bootstrap.Token) == "" && nodeClass.Spec.Bootstrap.TokenScope == nil) {
+		return fmt.Errorf("bootstrap mode %s requires bootstrap.tokenScope or bootstrap.token", mode)
+	}
+	if mode == v1.BootstrapModeKubeadm {
+		if _, err := caCertHashes(options.FromContext(ctx).ClusterCABundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kubeletConfiguration is the subset of the kubelet.config.k8s.io/v1beta1 KubeletConfiguration
+// that can be set from the NodeClass
+type kubeletConfiguration struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	*v1.KubeletConfiguration `json:",inline"`
+}
+
+// cloudConfig is the subset of the cloud-config format used to write files and run commands on the node
+type cloudConfig struct {
+	WriteFiles []writeFile `json:"write_files"`
+	RunCmd     []string    `json:"runcmd,omitempty"`
+}
+
+type writeFile struct {
+	Path        string `json:"path"`
+	Permissions string `json:"permissions"`
+	Content     string `json:"content"`
+}
+
+// bootstrapPart returns a cloud-config part joining the node to the cluster with the given bootstrap mode
+func bootstrapPart(ctx context.Context, mode string, nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim, token string) (Part, error) {
+	if err := validateBootstrap(ctx, nodeClass); err != nil {
+		return Part{}, err
+	}
+	if token == "" {
+		return Part{}, fmt.Errorf("bootstrap mode %s requires a join token", mode)
+	}
+	server := serverURL(ctx, nodeClass)
+	labels := nodeLabels(nodeClaim)
+	taints := registerTaints(nodeClaim)
+	kubeletArgs := kubeletFlags(nodeClass.Spec.Kubelet)
+
+	var config cloudConfig
+	switch mode {
+	case v1.BootstrapModeKubeadm:
+		hashes, err := caCertHashes(options.FromContext(ctx).ClusterCABundle)
+		if err != nil {
+			return Part{}, err
+		}
+		endpoint, err := url.Parse(server)
+		if err != nil {
+			return Part{}, fmt.Errorf("parsing server URL: %w", err)
+		}
+		joinConfig, err := yaml.Marshal(kubeadmJoinConfiguration(endpoint.Host, token, hashes, labels, taints, kubeletArgs))
+		if err != nil {
+			return Part{}, fmt.Errorf("marshaling kubeadm join configuration: %w", err)
+		}
+		config = cloudConfig{
+			WriteFiles: []writeFile{{Path: "/etc/kubernetes/karpenter/join-configuration.yaml", Permissions: "0600", Content: string(joinConfig)}},
+			RunCmd:     []string{"kubeadm join --config /etc/kubernetes/karpenter/join-configuration.yaml"},
+		}
+	case v1.BootstrapModeK3s, v1.BootstrapModeRKE2:
+		agentConfig, err := yaml.Marshal(map[string]any{
+			"server":      server,
+			"token":       token,
+			"node-label":  lo.Map(sortedKeys(labels), func(k string, _ int) string { return k + "=" + labels[k] }),
+			"node-taint":  lo.Map(taints, func(t corev1.Taint, _ int) string { return t.ToString() }),
+			"kubelet-arg": lo.Map(kubeletArgs, func(f flag, _ int) string { return f.Name + "=" + f.Value }),
+		})
+		if err != nil {
+			return Part{}, fmt.Errorf("marshaling %s agent configuration: %w", mode, err)
+		}
+		if mode == v1.BootstrapModeK3s {
+			config = cloudConfig{
+				WriteFiles: []writeFile{{Path: "/etc/rancher/k3s/config.yaml", Permissions: "0600", Content: string(agentConfig)}},
+				RunCmd: []string{
+					"command -v k3s >/dev/null || curl -sfL https://get.k3s.io | INSTALL_K3S_EXEC=agent INSTALL_K3S_SKIP_START=true sh -",
+					"systemctl enable --now k3s-agent.service",
+				},
+			}
+		} else {
+			config = cloudConfig{
+				WriteFiles: []writeFile{{Path: "/etc/rancher/rke2/config.yaml", Permissions: "0600", Content: string(agentConfig)}},
+				RunCmd: []string{
+					"command -v rke2 >/dev/null || curl -sfL https://get.rke2.io | INSTALL_RKE2_TYPE=agent sh -",
+					"systemctl enable --now rke2-agent.service",
+				},
+			}
+		}
+	default:
+		return Part{}, fmt.Errorf("unsupported bootstrap mode %s", mode)
+	}
+
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return Part{}, fmt.Errorf("marshaling cloud-config: %w", err)
+	}
+	return Part{
+		ContentType: "text/cloud-config",
+		Content:     "#cloud-config\n" + string(content),
+	}, nil
+}
+
+// flag is a kubelet command line flag
+type flag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// kubeadmJoinConfiguration returns a kubeadm.k8s.io/v1beta4 JoinConfiguration. The cluster CA is
+// pinned through its public key hashes, or not verified when no CA bundle is configured.
+func kubeadmJoinConfiguration(apiServerEndpoint, token string, caCertHashes []string, labels map[string]string, taints []corev1.Taint, kubeletArgs []flag) map[string]any {
+	bootstrapToken := map[string]any{
+		"apiServerEndpoint": apiServerEndpoint,
+		"token":             token,
+	}
+	if len(caCertHashes) > 0 {
+		bootstrapToken["caCertHashes"] = caCertHashes
+	} else {
+		bootstrapToken["unsafeSkipCAVerification"] = true
+	}
+	if len(labels) > 0 {
+		kubeletArgs = append([]flag{{
+			Name:  "node-labels",
+			Value: strings.Join(lo.Map(sortedKeys(labels), func(k string, _ int) string { return k + "=" + labels[k] }), ","),
+		}}, kubeletArgs...)
+	}
+	return map[string]any{
+		"apiVersion": "kubeadm.k8s.io/v1beta4",
+		"kind":       "JoinConfiguration",
+		"discovery": map[string]any{
+			"bootstrapToken": bootstrapToken,
+		},
+		"nodeRegistration": map[string]any{
+			"taints":           taints,
+			"kubeletExtraArgs": kubeletArgs,
+		},
+	}
+}
+
+// TemplateData is the data the NodeClass UserData template is rendered with
+type TemplateData struct {
+	// NodeClaimName is the name of the NodeClaim the node is launched for
+	NodeClaimName string
+	// NodePool is the name of the NodePool owning the NodeClaim
+	NodePool string
+	// Labels are the labels the kubelet can register the node with
+	Labels map[string]string
+	// Taints are the taints the kubelet must register the node with
+	Taints []corev1.Taint
+	// Zone is the CloudStack zone of the node
+	Zone string
+	// InstanceType is the service offering of the node
+	InstanceType string
+	// ClusterName is the name of the cluster
+	ClusterName string
+	// ClusterEndpoint is the URL of the cluster API server
+	ClusterEndpoint string
+	// BootstrapToken is the token the node joins the cluster with
+	BootstrapToken string
+}
+
+// templateFuncs are the functions available to the NodeClass UserData template, formatting
+// labels and taints the way --node-labels and --register-with-taints expect them
+var templateFuncs = template.FuncMap{
+	"joinLabels": func(labels map[string]string) string {
+		return joinMap(labels, "=")
+	},
+	"joinTaints": func(taints []corev1.Taint) string {
+		return strings.Join(lo.Map(taints, func(t corev1.Taint, _ int) string { return t.ToString() }), ",")
+	},
+}
+
+// goTemplateHeader is the header line opting user data into Go templating, after the
+// "## template: jinja" header cloud-init renders Jinja templates for
+const goTemplateHeader = "## template: go"
+
+// parse parses user data starting with the Go template header as a Go template, without the header.
+// Other user data isn't a template and nil is returned, so that literal {{ are left as is.
+func parse(userData string) (*template.Template, error) {
+	header, body, _ := strings.Cut(userData, "\n")
+	if strings.TrimSpace(header) != goTemplateHeader {
+		return nil, nil
+	}
+	return template.New("userData").Funcs(templateFuncs).Parse(body)
+}
+
+// render renders the user data template for a NodeClaim. User data that isn't a template is returned as is.
+func render(ctx context.Context, userData string, nodeClass *v1.CloudStackNodeClass, nodeClaim *karpv1.NodeClaim, instanceType string, token string) (string, error) {
+	tmpl, err := parse(userData)
+	if err != nil || tmpl == nil {
+		return userData, err
+	}
+	opts := options.FromContext(ctx)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, TemplateData{
+		NodeClaimName:   nodeClaim.Name,
+		NodePool:        nodeClaim.Labels[karpv1.NodePoolLabelKey],
+		Labels:          nodeLabels(nodeClaim),
+		Taints:          registerTaints(nodeClaim),
+		Zone:            nodeClass.Spec.Zone,
+		InstanceType:    instanceType,
+		ClusterName:     opts.ClusterName,
+		ClusterEndpoint: opts.ClusterEndpoint,
+		BootstrapToken:  token,
+	}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// nodeLabels returns the NodeClaim labels and single-valued requirements the kubelet can register
+// the node with. Restricted labels are either set by the kubelet itself or synced by Karpenter after registration.
+func nodeLabels(nodeClaim *karpv1.NodeClaim) map[string]string {
+	labels := lo.Assign(nodeClaim.Labels)
+	for _, requirement := range nodeClaim.Spec.Requirements {
+		if requirement.Operator == corev1.NodeSelectorOpIn && len(requirement.Values) == 1 {
+			labels[requirement.Key] = requirement.Values[0]
+		}
+	}
+	return lo.OmitBy(labels, func(key string, _ string) bool {
+		return karpv1.IsRestrictedNodeLabel(key)
+	})
+}
+
+// registerTaints returns the taints the node must register with to avoid pods being scheduled
+// before Karpenter and the startup daemons are done. Karpenter removes the unregistered taint
+// once it has synced the node with its NodeClaim.
+func registerTaints(nodeClaim *karpv1.NodeClaim) []corev1.Taint {
+	taints := append([]corev1.Taint{karpv1.UnregisteredNoExecuteTaint}, nodeClaim.Spec.Taints...)
+	return append(taints, nodeClaim.Spec.StartupTaints...)
+}
+
+// kubeletFlags converts the kubelet configuration into kubelet command line flags
+func kubeletFlags(kubelet *v1.KubeletConfiguration) []flag {
+	if kubelet == nil {
+		return nil
+	}
+	var flags []flag
+	if kubelet.MaxPods != nil {
+		flags = append(flags, flag{Name: "max-pods", Value: fmt.Sprint(*kubelet.MaxPods)})
+	}
+	if kubelet.PodsPerCore != nil {
+		flags = append(flags, flag{Name: "pods-per-core", Value: fmt.Sprint(*kubelet.PodsPerCore)})
+	}
+	if len(kubelet.KubeReserved) > 0 {
+		flags = append(flags, flag{Name: "kube-reserved", Value: joinMap(kubelet.KubeReserved, "=")})
+	}
+	if len(kubelet.SystemReserved) > 0 {
+		flags = append(flags, flag{Name: "system-reserved", Value: joinMap(kubelet.SystemReserved, "=")})
+	}
+	if len(kubelet.EvictionHard) > 0 {
+		flags = append(flags, flag{Name: "eviction-hard", Value: joinMap(kubelet.EvictionHard, "<")})
+	}
+	if len(kubelet.EvictionSoft) > 0 {
+		flags = append(flags, flag{Name: "eviction-soft", Value: joinMap(kubelet.EvictionSoft, "<")})
+	}
+	if len(kubelet.EvictionSoftGracePeriod) > 0 {
+		flags = append(flags, flag{Name: "eviction-soft-grace-period", Value: joinMap(lo.MapValues(kubelet.EvictionSoftGracePeriod, func(d metav1.Duration, _ string) string {
+			return d.Duration.String()
+		}), "=")})
+	}
+	if len(kubelet.ClusterDNS) > 0 {
+		flags = append(flags, flag{Name: "cluster-dns", Value: strings.Join(kubelet.ClusterDNS, ",")})
+	}
+	return flags
+}
+
+// joinMap joins the entries of a map sorted by key, e.g. "cpu=100m,memory=256Mi"
+func joinMap(m map[string]string, separator string) string {
+	return strings.Join(lo.Map(sortedKeys(m), func(k string, _ int) string { return k + separator + m[k] }), ",")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := lo.Keys(m)
+	sort.Strings(keys)
+	return keys
+}
+
+// serverURL returns the URL nodes join, from the NodeClass or from the operator options
+func serverURL(ctx context.Context, nodeClass *v1.CloudStackNodeClass) string {
+	if nodeClass.Spec.Bootstrap != nil && lo.FromPtr(nodeClass.Spec.Bootstrap.ServerURL) != "" {
+		return *nodeClass.Spec.Bootstrap.ServerURL
+	}
+	return options.FromContext(ctx).ClusterEndpoint
+}
+
+// caCertHashes returns the sha256 hashes of the public keys of the certificates in the
+// base64-encoded PEM CA bundle, as expected by kubeadm token discovery
+func caCertHashes(caBundle string) ([]string, error) {
+	if caBundle == "" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("decoding CA bundle: %w", err)
+	}
+	var hashes []string
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing CA certificate: %w", err)
+		}
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		hashes = append(hashes, "sha256:"+hex.EncodeToString(sum[:]))
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("CA bundle contains no certificate")
+	}
+	return hashes, nil
+}
+
+// KubeletConfigPart returns a cloud-config part writing the kubelet configuration as a drop-in file
+func KubeletConfigPart(kubelet *v1.KubeletConfiguration) (Part, error) {
+	config, err := yaml.Marshal(kubeletConfiguration{
+		APIVersion:           "kubelet.config.k8s.io/v1beta1",
+		Kind:                 "KubeletConfiguration",
+		KubeletConfiguration: kubelet,
+	})
+	if err != nil {
+		return Part{}, fmt.Errorf("marshaling kubelet configuration: %w", err)
+	}
+	content, err := yaml.Marshal(cloudConfig{
+		WriteFiles: []writeFile{{
+			Path:        KubeletConfigPath,
+			Permissions: "0644",
+			Content:     string(config),
+		}},
+	})
+	if err != nil {
+		return Part{}, fmt.Errorf("marshaling cloud-config: %w", err)
+	}
+	return Part{
+		ContentType: "text/cloud-config",
+		Content:     "#cloud-config\n" + string(content),
+	}, nil
+}
+
+// Parts splits user data into MIME parts. A MIME multipart document is split into its parts,
+// any other user data is a single part whose content type is detected from its header line.
+func Parts(userData string) ([]Part, error) {
+	if strings.TrimSpace(userData) == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(userData, "MIME-Version:") && !strings.HasPrefix(userData, "Content-Type:") {
+		return []Part{{ContentType: contentType(userData), Content: userData}}, nil
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(userData))
+	if err != nil {
+		return nil, fmt.Errorf("reading MIME document: %w", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing MIME content type: %w", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := io.ReadAll(message.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading MIME body: %w", err)
+		}
+		return []Part{{ContentType: mediaType, Content: string(body)}}, nil
+	}
+
+	var parts []Part
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading MIME part: %w", err)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("reading MIME part: %w", err)
+		}
+		partType := part.Header.Get("Content-Type")
+		if partType == "" {
+			partType = contentType(string(content))
+		}
+		parts = append(parts, Part{ContentType: partType, Content: string(content)})
+	}
+	return parts, nil
+}
+
+// Merge returns a MIME multipart document containing the given parts in order
+func Merge(parts []Part) (string, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.SetBoundary(mimeBoundary); err != nil {
+		return "", fmt.Errorf("setting MIME boundary: %w", err)
+	}
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.ContentType)
+		if part.ContentType == "text/cloud-config" {
+			header.Set("Merge-Type", cloudConfigMergeType)
+		}
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return "", fmt.Errorf("creating MIME part: %w", err)
+		}
+		if _, err := io.WriteString(w, part.Content); err != nil {
+			return "", fmt.Errorf("writing MIME part: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("closing MIME document: %w", err)
+	}
+	return fmt.Sprintf("MIME-Version: 1.0\nContent-Type: multipart/mixed; boundary=%q\n\n%s", mimeBoundary, buf.String()), nil
+}
+
+// contentType detects the cloud-init content type of user data from its header line
+func contentType(userData string) string {
+	switch {
+	case strings.HasPrefix(userData, "#cloud-config"):
+		return "text/cloud-config"
+	case strings.HasPrefix(userData, "#cloud-boothook"):
+		return "text/cloud-boothook"
+	case strings.HasPrefix(userData, "#include"):
+		return "text/x-include-url"
+	case strings.HasPrefix(userData, "## template: jinja"):
+		return "text/jinja2"
+	default:
+		return "text/x-shellscript"
+	}
+}
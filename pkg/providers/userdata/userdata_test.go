@@ -0,0 +1,413 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+)
+
+func TestParts(t *testing.T) {
+	tests := []struct {
+		name     string
+		userData string
+		want     []Part
+		wantErr  bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "shell script",
+			userData: "#!/bin/bash\necho hello\n",
+			want:     []Part{{ContentType: "text/x-shellscript", Content: "#!/bin/bash\necho hello\n"}},
+		},
+		{
+			name:     "cloud-config",
+			userData: "#cloud-config\nruncmd: []\n",
+			want:     []Part{{ContentType: "text/cloud-config", Content: "#cloud-config\nruncmd: []\n"}},
+		},
+		{
+			name:     "jinja template",
+			userData: "## template: jinja\n#cloud-config\n",
+			want:     []Part{{ContentType: "text/jinja2", Content: "## template: jinja\n#cloud-config\n"}},
+		},
+		{
+			name:     "single MIME part",
+			userData: "Content-Type: text/cloud-config\n\n#cloud-config\n",
+			want:     []Part{{ContentType: "text/cloud-config", Content: "#cloud-config\n"}},
+		},
+		{
+			name: "MIME multipart document",
+			userData: "MIME-Version: 1.0\nContent-Type: multipart/mixed; boundary=\"b\"\n\n" +
+				"--b\nContent-Type: text/cloud-config\n\n#cloud-config\n" +
+				"--b\n\n#!/bin/bash\necho hello\n--b--\n",
+			want: []Part{
+				{ContentType: "text/cloud-config", Content: "#cloud-config"},
+				{ContentType: "text/x-shellscript", Content: "#!/bin/bash\necho hello"},
+			},
+		},
+		{
+			name:     "invalid MIME content type",
+			userData: "Content-Type: ;\n\n#cloud-config\n",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := Parts(tt.userData)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(parts, tt.want) {
+				t.Errorf("Parts() = %q, want %q", parts, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []Part
+	}{
+		{
+			name:  "single part",
+			parts: []Part{{ContentType: "text/x-shellscript", Content: "#!/bin/bash\necho hello"}},
+		},
+		{
+			name: "parts are kept in order",
+			parts: []Part{
+				{ContentType: "text/cloud-config", Content: "#cloud-config\nwrite_files: []"},
+				{ContentType: "text/x-shellscript", Content: "#!/bin/bash\necho hello"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := Merge(tt.parts)
+			if err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			parts, err := Parts(merged)
+			if err != nil {
+				t.Fatalf("Parts() error = %v", err)
+			}
+			if !reflect.DeepEqual(parts, tt.parts) {
+				t.Errorf("Parts(Merge()) = %q, want %q", parts, tt.parts)
+			}
+			// cloud-config parts are merged with the user's cloud-config rather than replacing it
+			if got, want := strings.Count(merged, "Merge-Type: "+cloudConfigMergeType), strings.Count(merged, "Content-Type: text/cloud-config"); got != want {
+				t.Errorf("Merge() set the merge type on %d parts, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	ctx := options.ToContext(context.Background(), &options.Options{ClusterName: "test-cluster", ClusterEndpoint: "https://10.0.0.1:6443"})
+	nodeClass := &v1.CloudStackNodeClass{Spec: v1.CloudStackNodeClassSpec{Zone: "zone-a"}}
+	nodeClaim := &karpv1.NodeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default-abcde",
+			Labels: map[string]string{
+				karpv1.NodePoolLabelKey: "default",
+				"team":                  "a",
+				corev1.LabelHostname:    "restricted",
+			},
+		},
+		Spec: karpv1.NodeClaimSpec{
+			Taints: []corev1.Taint{{Key: "dedicated", Value: "a", Effect: corev1.TaintEffectNoSchedule}},
+			Requirements: []karpv1.NodeSelectorRequirementWithMinValues{
+				{NodeSelectorRequirement: corev1.NodeSelectorRequirement{Key: "env", Operator: corev1.NodeSelectorOpIn, Values: []string{"prod"}}},
+				{NodeSelectorRequirement: corev1.NodeSelectorRequirement{Key: "tier", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}}},
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		userData string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "plain user data",
+			userData: "#!/bin/bash\necho hello\n",
+			want:     "#!/bin/bash\necho hello\n",
+		},
+		{
+			name:     "NodeClaim, cluster and instance fields",
+			userData: "## template: go\n{{ .NodeClaimName }} {{ .NodePool }} {{ .Zone }} {{ .InstanceType }} {{ .ClusterName }} {{ .ClusterEndpoint }} {{ .BootstrapToken }}",
+			want:     "default-abcde default zone-a small test-cluster https://10.0.0.1:6443 abcdef.0123456789abcdef",
+		},
+		{
+			name:     "labels include single-valued requirements and leave out restricted labels",
+			userData: "## template: go\n--node-labels={{ joinLabels .Labels }}",
+			want:     "--node-labels=env=prod,team=a",
+		},
+		{
+			name:     "taints register the node unregistered",
+			userData: "## template: go\n--register-with-taints={{ joinTaints .Taints }}",
+			want:     "--register-with-taints=karpenter.sh/unregistered:NoExecute,dedicated=a:NoSchedule",
+		},
+		{
+			name:     "the header line is removed",
+			userData: "## template: go\r\n#!/bin/bash\necho {{ .NodeClaimName }}\n",
+			want:     "#!/bin/bash\necho default-abcde\n",
+		},
+		{
+			name:     "user data without the header isn't a template",
+			userData: "#!/bin/bash\ndocker inspect --format '{{.State.Running}}' kubelet\n",
+			want:     "#!/bin/bash\ndocker inspect --format '{{.State.Running}}' kubelet\n",
+		},
+		{
+			name:     "the header must be the first line",
+			userData: "#!/bin/bash\n## template: go\necho {{ .NodeClaimName }}\n",
+			want:     "#!/bin/bash\n## template: go\necho {{ .NodeClaimName }}\n",
+		},
+		{
+			name:     "jinja templates are left to cloud-init",
+			userData: "## template: jinja\n{{ v1.local_hostname }}",
+			want:     "## template: jinja\n{{ v1.local_hostname }}",
+		},
+		{
+			name:     "invalid template",
+			userData: "## template: go\n{{ .NodeClaimName",
+			wantErr:  true,
+		},
+		{
+			name:     "unknown field",
+			userData: "## template: go\n{{ .Unknown }}",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := render(ctx, tt.userData, nodeClass, nodeClaim, "small", validationToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	ctx := options.ToContext(context.Background(), &options.Options{UserDataMaxLength: 256})
+	script := "#!/bin/bash\n" + strings.Repeat("echo hello\n", 20)
+	tests := []struct {
+		name        string
+		compression *string
+		userData    string
+		wantErr     error
+	}{
+		{
+			name:     "uncompressed",
+			userData: "#!/bin/bash\necho hello\n",
+		},
+		{
+			name:     "uncompressed over the limit",
+			userData: script,
+			wantErr:  ErrTooLarge,
+		},
+		{
+			name:        "compressed under the limit",
+			compression: lo.ToPtr(v1.UserDataCompressionGzip),
+			userData:    script,
+		},
+		{
+			name:        "no compression",
+			compression: lo.ToPtr(v1.UserDataCompressionNone),
+			userData:    script,
+			wantErr:     ErrTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeClass := &v1.CloudStackNodeClass{Spec: v1.CloudStackNodeClassSpec{UserDataCompression: tt.compression}}
+			encoded, err := Encode(ctx, nodeClass, tt.userData)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			data, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatalf("decoding user data: %v", err)
+			}
+			if lo.FromPtr(tt.compression) == v1.UserDataCompressionGzip {
+				r, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("decompressing user data: %v", err)
+				}
+				if data, err = io.ReadAll(r); err != nil {
+					t.Fatalf("decompressing user data: %v", err)
+				}
+			}
+			if string(data) != tt.userData {
+				t.Errorf("Encode() decodes to %q, want %q", data, tt.userData)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ctx := options.ToContext(context.Background(), &options.Options{ClusterEndpoint: "https://10.0.0.1:6443", UserDataMaxLength: 768})
+	script := "## template: go\n#!/bin/bash\necho {{ .NodeClaimName }}\n"
+	tests := []struct {
+		name     string
+		spec     v1.CloudStackNodeClassSpec
+		wantErr  bool
+		wantSize bool
+	}{
+		{
+			name: "no user data",
+		},
+		{
+			name: "user data template",
+			spec: v1.CloudStackNodeClassSpec{UserData: lo.ToPtr(script)},
+		},
+		{
+			name:    "invalid user data template",
+			spec:    v1.CloudStackNodeClassSpec{UserData: lo.ToPtr("## template: go\n{{ .NodeClaimName")},
+			wantErr: true,
+		},
+		{
+			name:     "user data over the limit",
+			spec:     v1.CloudStackNodeClassSpec{UserData: lo.ToPtr(script + strings.Repeat("echo hello\n", 100))},
+			wantErr:  true,
+			wantSize: true,
+		},
+		{
+			name: "compressed user data under the limit",
+			spec: v1.CloudStackNodeClassSpec{
+				UserData:            lo.ToPtr(script + strings.Repeat("echo hello\n", 100)),
+				UserDataCompression: lo.ToPtr(v1.UserDataCompressionGzip),
+			},
+		},
+		{
+			name:    "bootstrap mode without a token",
+			spec:    v1.CloudStackNodeClassSpec{BootstrapMode: lo.ToPtr(v1.BootstrapModeK3s)},
+			wantErr: true,
+		},
+		{
+			name: "user data generated for a bootstrap mode over the limit",
+			spec: v1.CloudStackNodeClassSpec{
+				UserData:      lo.ToPtr(script),
+				BootstrapMode: lo.ToPtr(v1.BootstrapModeK3s),
+				Bootstrap:     &v1.BootstrapConfiguration{TokenScope: lo.ToPtr(v1.TokenScopeLaunch)},
+			},
+			wantErr:  true,
+			wantSize: true,
+		},
+		{
+			name: "compressed user data generated for a bootstrap mode under the limit",
+			spec: v1.CloudStackNodeClassSpec{
+				UserData:            lo.ToPtr(script),
+				UserDataCompression: lo.ToPtr(v1.UserDataCompressionGzip),
+				BootstrapMode:       lo.ToPtr(v1.BootstrapModeK3s),
+				Bootstrap:           &v1.BootstrapConfiguration{Token: lo.ToPtr(validationToken)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewDefaultProvider(nil, nil)
+			err := p.Validate(ctx, &v1.CloudStackNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: tt.spec})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrTooLarge) != tt.wantSize {
+				t.Errorf("Validate() error = %v, want ErrTooLarge %v", err, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestBootstrapPart(t *testing.T) {
+	caBundle := base64.StdEncoding.EncodeToString(lo.Must(os.ReadFile("testdata/ca.crt")))
+	nodeClaim := &karpv1.NodeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "default-abcde",
+			Labels: map[string]string{karpv1.NodePoolLabelKey: "default", "team": "a"},
+		},
+		Spec: karpv1.NodeClaimSpec{
+			Taints: []corev1.Taint{{Key: "dedicated", Value: "a", Effect: corev1.TaintEffectNoSchedule}},
+		},
+	}
+	kubelet := &v1.KubeletConfiguration{MaxPods: lo.ToPtr[int32](110), ClusterDNS: []string{"10.96.0.10"}}
+	tests := []struct {
+		name     string
+		mode     string
+		caBundle string
+		token    string
+		golden   string
+		wantErr  bool
+	}{
+		{name: "kubeadm pinning the cluster CA", mode: v1.BootstrapModeKubeadm, caBundle: caBundle, token: validationToken, golden: "kubeadm.golden"},
+		{name: "kubeadm without a CA bundle", mode: v1.BootstrapModeKubeadm, token: validationToken, golden: "kubeadm-unsafe-skip-ca-verification.golden"},
+		{name: "kubeadm with an invalid CA bundle", mode: v1.BootstrapModeKubeadm, caBundle: "not base64", token: validationToken, wantErr: true},
+		{name: "k3s", mode: v1.BootstrapModeK3s, token: validationToken, golden: "k3s.golden"},
+		{name: "RKE2", mode: v1.BootstrapModeRKE2, token: validationToken, golden: "rke2.golden"},
+		{name: "no join token", mode: v1.BootstrapModeK3s, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := options.ToContext(context.Background(), &options.Options{ClusterEndpoint: "https://10.0.0.1:6443", ClusterCABundle: tt.caBundle})
+			nodeClass := &v1.CloudStackNodeClass{Spec: v1.CloudStackNodeClassSpec{
+				BootstrapMode: lo.ToPtr(tt.mode),
+				Bootstrap:     &v1.BootstrapConfiguration{TokenScope: lo.ToPtr(v1.TokenScopeLaunch)},
+				Kubelet:       kubelet,
+			}}
+			part, err := bootstrapPart(ctx, tt.mode, nodeClass, nodeClaim, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bootstrapPart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if part.ContentType != "text/cloud-config" {
+				t.Errorf("bootstrapPart() content type = %q, want text/cloud-config", part.ContentType)
+			}
+			want, err := os.ReadFile(filepath.Join("testdata", tt.golden))
+			if err != nil {
+				t.Fatalf("reading golden file: %v", err)
+			}
+			if part.Content != string(want) {
+				t.Errorf("bootstrapPart() =\n%s\nwant\n%s", part.Content, want)
+			}
+		})
+	}
+}
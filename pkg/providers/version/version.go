@@ -0,0 +1,88 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/patrickmn/go-cache"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	kubernetesVersionCacheKey = "kubernetes-version"
+)
+
+// Provider provides the Kubernetes version of the API server
+type Provider interface {
+	Get(ctx context.Context) (string, error)
+	UpdateVersion(ctx context.Context) error
+}
+
+// DefaultProvider implements the Version Provider
+type DefaultProvider struct {
+	kubernetesInterface kubernetes.Interface
+	cache               *cache.Cache
+	mu                  sync.Mutex
+}
+
+// NewDefaultProvider creates a new version provider
+func NewDefaultProvider(kubernetesInterface kubernetes.Interface, cache *cache.Cache) *DefaultProvider {
+	return &DefaultProvider{
+		kubernetesInterface: kubernetesInterface,
+		cache:               cache,
+	}
+}
+
+// Get returns the "major.minor" Kubernetes version of the API server
+func (p *DefaultProvider) Get(ctx context.Context) (string, error) {
+	// Check cache first
+	if cached, found := p.cache.Get(kubernetesVersionCacheKey); found {
+		return cached.(string), nil
+	}
+	if err := p.UpdateVersion(ctx); err != nil {
+		return "", err
+	}
+	cached, _ := p.cache.Get(kubernetesVersionCacheKey)
+	return cached.(string), nil
+}
+
+// UpdateVersion discovers the Kubernetes version of the API server, so that control plane
+// upgrades are picked up
+func (p *DefaultProvider) UpdateVersion(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	serverVersion, err := p.kubernetesInterface.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("getting kubernetes server version: %w", err)
+	}
+	v, err := version.ParseGeneric(serverVersion.GitVersion)
+	if err != nil {
+		return fmt.Errorf("parsing kubernetes server version %s: %w", serverVersion.GitVersion, err)
+	}
+	kubernetesVersion := fmt.Sprintf("%d.%d", v.Major(), v.Minor())
+
+	if cached, found := p.cache.Get(kubernetesVersionCacheKey); !found || cached.(string) != kubernetesVersion {
+		log.FromContext(ctx).Info("Discovered kubernetes version", "version", kubernetesVersion)
+	}
+	p.cache.Set(kubernetesVersionCacheKey, kubernetesVersion, cache.DefaultExpiration)
+
+	return nil
+}
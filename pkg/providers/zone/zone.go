@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/apache/cloudstack-go/v2/cloudstack"
 	"github.com/patrickmn/go-cache"
 	"github.com/samber/lo"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -31,6 +32,9 @@ type Provider interface {
 	List(ctx context.Context) ([]*Zone, error)
 	Get(ctx context.Context, id string) (*Zone, error)
 	GetByName(ctx context.Context, name string) (*Zone, error)
+	Hypervisors(ctx context.Context, zone string) ([]string, error)
+	ValidateZone(ctx context.Context, zone string) error
+	InvalidateCache()
 }
 
 // Zone represents a CloudStack zone
@@ -139,6 +143,59 @@ func (p *DefaultProvider) GetByName(ctx context.Context, name string) (*Zone, er
 	return zone, nil
 }
 
+// Hypervisors returns the hypervisors of the clusters in a zone, given by name or ID
+func (p *DefaultProvider) Hypervisors(ctx context.Context, zoneIdentifier string) ([]string, error) {
+	cacheKey := fmt.Sprintf("hypervisors-%s", zoneIdentifier)
+
+	// Check cache first
+	if cached, found := p.cache.Get(cacheKey); found {
+		return cached.([]string), nil
+	}
+
+	zones, err := p.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	zone, found := lo.Find(zones, func(z *Zone) bool {
+		return z.ID == zoneIdentifier || z.Name == zoneIdentifier
+	})
+	if !found {
+		return nil, fmt.Errorf("zone %s not found", zoneIdentifier)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Double-check after acquiring lock
+	if cached, found := p.cache.Get(cacheKey); found {
+		return cached.([]string), nil
+	}
+
+	params := p.csClient.(*csapi.Client).Hypervisor.NewListHypervisorsParams()
+	params.SetZoneid(zone.ID)
+
+	resp, err := p.csClient.ListHypervisors(params)
+	if err != nil {
+		return nil, fmt.Errorf("listing hypervisors in zone %s: %w", zoneIdentifier, err)
+	}
+
+	hypervisors := lo.Uniq(lo.Map(resp.Hypervisors, func(h *cloudstack.Hypervisor, _ int) string {
+		return h.Name
+	}))
+
+	// Cache the results
+	p.cache.Set(cacheKey, hypervisors, cache.DefaultExpiration)
+
+	log.FromContext(ctx).Info("Listed hypervisors", "zone", zoneIdentifier, "hypervisors", hypervisors)
+
+	return hypervisors, nil
+}
+
+// InvalidateCache drops the cached zones and hypervisors, so that they're listed again on next use
+func (p *DefaultProvider) InvalidateCache() {
+	p.cache.Flush()
+}
+
 // ValidateZone validates that a zone exists and is available
 func (p *DefaultProvider) ValidateZone(ctx context.Context, zoneIdentifier string) error {
 	zones, err := p.List(ctx)
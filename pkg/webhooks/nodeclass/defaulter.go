@@ -0,0 +1,77 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeclass
+
+import (
+	"context"
+
+	"github.com/samber/lo"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+)
+
+// maxRootDiskSize is the largest root disk size accepted by the CRD, in GB
+const maxRootDiskSize = 1000
+
+// Defaulter fills the NodeClass defaults computed from CloudStack when a NodeClass is created: the root
+// disk size from the template size, and the OS type of the templates selected by ID. Updated NodeClasses
+// aren't defaulted, as defaulting them would change their hash and drift their nodes.
+type Defaulter struct {
+	templateProvider template.Provider
+}
+
+// NewDefaulter creates a new NodeClass defaulter
+func NewDefaulter(templateProvider template.Provider) *Defaulter {
+	return &Defaulter{
+		templateProvider: templateProvider,
+	}
+}
+
+// Default fills the defaults of a created NodeClass. Templates failing to resolve are left to the
+// validating webhook and the NodeClass status to report.
+func (d *Defaulter) Default(ctx context.Context, obj runtime.Object) error {
+	nodeClass := obj.(*v1.CloudStackNodeClass)
+	if req, err := admission.RequestFromContext(ctx); err != nil || req.Operation != admissionv1.Create {
+		return nil
+	}
+
+	templates, err := d.templateProvider.ResolveTemplates(ctx, nodeClass.Spec.TemplateSelectorTerms, nodeClass.Spec.Zone)
+	if err != nil {
+		log.FromContext(ctx).V(1).Info("Skipped defaulting NodeClass from its templates", "nodeclass", nodeClass.Name, "error", err)
+		return nil
+	}
+
+	// Default the root disk to the size of the template new nodes launch with, rounded up to GB
+	if nodeClass.Spec.RootDiskSize == nil && templates[0].Size > 0 {
+		if size := (templates[0].Size + 1<<30 - 1) >> 30; size <= maxRootDiskSize {
+			nodeClass.Spec.RootDiskSize = lo.ToPtr(size)
+		}
+	}
+
+	for i, term := range nodeClass.Spec.TemplateSelectorTerms {
+		if term.ID == "" || term.OSType != "" {
+			continue
+		}
+		if t, ok := lo.Find(templates, func(t *template.Template) bool { return t.ID == term.ID }); ok {
+			nodeClass.Spec.TemplateSelectorTerms[i].OSType = t.OSTypeName
+		}
+	}
+	return nil
+}
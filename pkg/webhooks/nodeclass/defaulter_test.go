@@ -0,0 +1,118 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeclass
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/samber/lo"
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+)
+
+// fakeTemplateProvider resolves the templates of a NodeClass to the given templates
+type fakeTemplateProvider struct {
+	template.Provider
+	templates []*template.Template
+}
+
+func (f *fakeTemplateProvider) ResolveTemplates(context.Context, []v1.TemplateSelectorTerm, string) ([]*template.Template, error) {
+	if len(f.templates) == 0 {
+		return nil, fmt.Errorf("no templates matched the selector terms")
+	}
+	return f.templates, nil
+}
+
+func TestDefault(t *testing.T) {
+	templates := []*template.Template{
+		{ID: "template-1", OSTypeName: "Ubuntu 24.04 LTS", Size: 8<<30 + 1},
+		{ID: "template-2", OSTypeName: "Debian GNU/Linux 12 (64-bit)", Size: 4 << 30},
+	}
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		templates []*template.Template
+		spec      v1.CloudStackNodeClassSpec
+		want      v1.CloudStackNodeClassSpec
+	}{
+		{
+			name:      "root disk size from the first template, rounded up to GB",
+			operation: admissionv1.Create,
+			templates: templates,
+			spec:      v1.CloudStackNodeClassSpec{TemplateSelectorTerms: []v1.TemplateSelectorTerm{{Tags: map[string]string{"os": "ubuntu"}}}},
+			want: v1.CloudStackNodeClassSpec{
+				TemplateSelectorTerms: []v1.TemplateSelectorTerm{{Tags: map[string]string{"os": "ubuntu"}}},
+				RootDiskSize:          lo.ToPtr[int64](9),
+			},
+		},
+		{
+			name:      "root disk size set",
+			operation: admissionv1.Create,
+			templates: templates,
+			spec: v1.CloudStackNodeClassSpec{
+				TemplateSelectorTerms: []v1.TemplateSelectorTerm{{Tags: map[string]string{"os": "ubuntu"}}},
+				RootDiskSize:          lo.ToPtr[int64](50),
+			},
+			want: v1.CloudStackNodeClassSpec{
+				TemplateSelectorTerms: []v1.TemplateSelectorTerm{{Tags: map[string]string{"os": "ubuntu"}}},
+				RootDiskSize:          lo.ToPtr[int64](50),
+			},
+		},
+		{
+			name:      "OS type of the terms selecting a template by ID",
+			operation: admissionv1.Create,
+			templates: templates,
+			spec: v1.CloudStackNodeClassSpec{
+				TemplateSelectorTerms: []v1.TemplateSelectorTerm{{ID: "template-2"}, {ID: "template-1", OSType: "Ubuntu"}, {ID: "missing"}},
+				RootDiskSize:          lo.ToPtr[int64](50),
+			},
+			want: v1.CloudStackNodeClassSpec{
+				TemplateSelectorTerms: []v1.TemplateSelectorTerm{{ID: "template-2", OSType: "Debian GNU/Linux 12 (64-bit)"}, {ID: "template-1", OSType: "Ubuntu"}, {ID: "missing"}},
+				RootDiskSize:          lo.ToPtr[int64](50),
+			},
+		},
+		{
+			name:      "templates failing to resolve",
+			operation: admissionv1.Create,
+			spec:      v1.CloudStackNodeClassSpec{TemplateSelectorTerms: []v1.TemplateSelectorTerm{{ID: "missing"}}},
+			want:      v1.CloudStackNodeClassSpec{TemplateSelectorTerms: []v1.TemplateSelectorTerm{{ID: "missing"}}},
+		},
+		{
+			name:      "updates aren't defaulted",
+			operation: admissionv1.Update,
+			templates: templates,
+			spec:      v1.CloudStackNodeClassSpec{TemplateSelectorTerms: []v1.TemplateSelectorTerm{{ID: "template-1"}}},
+			want:      v1.CloudStackNodeClassSpec{TemplateSelectorTerms: []v1.TemplateSelectorTerm{{ID: "template-1"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: tt.operation}})
+			nodeClass := &v1.CloudStackNodeClass{Spec: tt.spec}
+			if err := NewDefaulter(&fakeTemplateProvider{templates: tt.templates}).Default(ctx, nodeClass); err != nil {
+				t.Fatalf("Default() error = %v", err)
+			}
+			if !reflect.DeepEqual(nodeClass.Spec, tt.want) {
+				t.Errorf("Default() = %+v, want %+v", nodeClass.Spec, tt.want)
+			}
+		})
+	}
+}
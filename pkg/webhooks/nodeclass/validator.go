@@ -0,0 +1,151 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeclass
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/sshkeypair"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/userdata"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
+)
+
+// Validator validates NodeClasses at admission against CloudStack, covering what the CRD validation
+// rules can't check: the zone, SSH key pair and disk offerings exist, the user data renders within the
+// CloudStack limit, and the tags don't collide with the tags Karpenter sets on instances
+type Validator struct {
+	options              *options.Options
+	zoneProvider         zone.Provider
+	diskOfferingProvider diskoffering.Provider
+	sshKeyPairProvider   sshkeypair.Provider
+	userDataProvider     userdata.Provider
+}
+
+// NewValidator creates a new NodeClass validator
+func NewValidator(
+	ctx context.Context,
+	zoneProvider zone.Provider,
+	diskOfferingProvider diskoffering.Provider,
+	sshKeyPairProvider sshkeypair.Provider,
+	userDataProvider userdata.Provider,
+) *Validator {
+	return &Validator{
+		options:              options.FromContext(ctx),
+		zoneProvider:         zoneProvider,
+		diskOfferingProvider: diskOfferingProvider,
+		sshKeyPairProvider:   sshKeyPairProvider,
+		userDataProvider:     userDataProvider,
+	}
+}
+
+// ValidateCreate validates a created NodeClass
+func (v *Validator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return v.validate(ctx, obj.(*v1.CloudStackNodeClass))
+}
+
+// ValidateUpdate validates an updated NodeClass. Updates leaving the spec unchanged, such as removing
+// the termination finalizer, are admitted even if CloudStack resources went away in the meantime.
+func (v *Validator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldNodeClass, nodeClass := oldObj.(*v1.CloudStackNodeClass), newObj.(*v1.CloudStackNodeClass)
+	if !nodeClass.DeletionTimestamp.IsZero() || equality.Semantic.DeepEqual(oldNodeClass.Spec, nodeClass.Spec) {
+		return nil, nil
+	}
+	return v.validate(ctx, nodeClass)
+}
+
+// ValidateDelete admits deleted NodeClasses
+func (v *Validator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate denies the NodeClass when it's invalid, or admits it with warnings in warn mode
+func (v *Validator) validate(ctx context.Context, nodeClass *v1.CloudStackNodeClass) (admission.Warnings, error) {
+	// Admission requests don't carry the operator context
+	ctx = options.ToContext(ctx, v.options)
+
+	errs := v.validateSpec(ctx, nodeClass)
+	if len(errs) == 0 {
+		return nil, nil
+	}
+	if v.options.NodeClassValidationMode == options.ValidationModeWarn {
+		log.FromContext(ctx).Info("Admitted invalid NodeClass", "nodeclass", nodeClass.Name, "errors", errs.ToAggregate().Error())
+		return lo.Map(errs, func(err *field.Error, _ int) string { return err.Error() }), nil
+	}
+	return nil, apierrors.NewInvalid(v1.SchemeGroupVersion.WithKind("CloudStackNodeClass").GroupKind(), nodeClass.Name, errs)
+}
+
+// validateSpec returns the problems of the NodeClass spec
+func (v *Validator) validateSpec(ctx context.Context, nodeClass *v1.CloudStackNodeClass) field.ErrorList {
+	spec := field.NewPath("spec")
+	var errs field.ErrorList
+
+	if err := v.zoneProvider.ValidateZone(ctx, nodeClass.Spec.Zone); err != nil {
+		errs = append(errs, field.Invalid(spec.Child("zone"), nodeClass.Spec.Zone, err.Error()))
+	}
+
+	if nodeClass.Spec.SSHKeyPair != nil {
+		if _, err := v.sshKeyPairProvider.Get(ctx, *nodeClass.Spec.SSHKeyPair); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("sshKeyPair"), *nodeClass.Spec.SSHKeyPair, err.Error()))
+		}
+	}
+
+	if nodeClass.Spec.RootDiskOffering != nil {
+		if _, err := v.diskOfferingProvider.ResolveRootDiskOffering(ctx, nodeClass.Spec.RootDiskOffering, nodeClass.Spec.Zone); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("rootDiskOffering"), field.OmitValueType{}, err.Error()))
+		}
+	}
+	if nodeClass.Spec.DiskOffering != nil {
+		offering, err := v.diskOfferingProvider.Resolve(ctx, *nodeClass.Spec.DiskOffering, nodeClass.Spec.Zone)
+		if err == nil {
+			err = diskoffering.Validate(offering, nodeClass.Spec.DataDiskSize, nodeClass.Spec.DataDiskMinIOPS, nodeClass.Spec.DataDiskMaxIOPS)
+		}
+		if err != nil {
+			errs = append(errs, field.Invalid(spec.Child("diskOffering"), *nodeClass.Spec.DiskOffering, err.Error()))
+		}
+	}
+	for i, mapping := range nodeClass.Spec.BlockDeviceMappings {
+		offering, err := v.diskOfferingProvider.Resolve(ctx, mapping.DiskOffering, nodeClass.Spec.Zone)
+		if err == nil {
+			err = diskoffering.Validate(offering, mapping.Size, mapping.MinIOPS, mapping.MaxIOPS)
+		}
+		if err != nil {
+			errs = append(errs, field.Invalid(spec.Child("blockDeviceMappings").Index(i).Child("diskOffering"), mapping.DiskOffering, err.Error()))
+		}
+	}
+
+	if err := v.userDataProvider.Validate(ctx, nodeClass); err != nil {
+		errs = append(errs, field.Invalid(spec.Child("userData"), field.OmitValueType{}, err.Error()))
+	}
+
+	for key := range nodeClass.Spec.Tags {
+		if key == v1.ManagedByTagKey || strings.HasPrefix(key, v1.ClusterNameTagKey) {
+			errs = append(errs, field.Invalid(spec.Child("tags").Key(key), key, fmt.Sprintf("tag collides with the %s or %s/<cluster> tags set by Karpenter", v1.ManagedByTagKey, v1.ClusterNameTagKey)))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,219 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeclass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/sshkeypair"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/userdata"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
+)
+
+// fakeZoneProvider knows a single zone
+type fakeZoneProvider struct{ zone.Provider }
+
+func (f *fakeZoneProvider) ValidateZone(_ context.Context, name string) error {
+	if name != "zone-a" {
+		return fmt.Errorf("zone %s not found", name)
+	}
+	return nil
+}
+
+// fakeDiskOfferingProvider resolves the given disk offerings by name
+type fakeDiskOfferingProvider struct {
+	diskoffering.Provider
+	offerings map[string]*diskoffering.DiskOffering
+}
+
+func (f *fakeDiskOfferingProvider) Resolve(_ context.Context, nameOrID string, _ string) (*diskoffering.DiskOffering, error) {
+	if offering, ok := f.offerings[nameOrID]; ok {
+		return offering, nil
+	}
+	return nil, fmt.Errorf("disk offering %s not found", nameOrID)
+}
+
+func (f *fakeDiskOfferingProvider) ResolveRootDiskOffering(ctx context.Context, selector *v1.RootDiskOfferingSelector, zone string) (*diskoffering.DiskOffering, error) {
+	return f.Resolve(ctx, selector.Name, zone)
+}
+
+// fakeSSHKeyPairProvider knows a single SSH key pair
+type fakeSSHKeyPairProvider struct{ sshkeypair.Provider }
+
+func (f *fakeSSHKeyPairProvider) Get(_ context.Context, name string) (*sshkeypair.SSHKeyPair, error) {
+	if name != "karpenter" {
+		return nil, fmt.Errorf("SSH key pair %s not found", name)
+	}
+	return &sshkeypair.SSHKeyPair{Name: name}, nil
+}
+
+// fakeUserDataProvider fails to validate user data containing "invalid"
+type fakeUserDataProvider struct{ userdata.Provider }
+
+func (f *fakeUserDataProvider) Validate(_ context.Context, nodeClass *v1.CloudStackNodeClass) error {
+	if lo.FromPtr(nodeClass.Spec.UserData) == "invalid" {
+		return fmt.Errorf("parsing user data template")
+	}
+	return nil
+}
+
+func newTestValidator(mode string) *Validator {
+	ctx := options.ToContext(context.Background(), &options.Options{NodeClassValidationMode: mode})
+	return NewValidator(ctx, &fakeZoneProvider{}, &fakeDiskOfferingProvider{offerings: map[string]*diskoffering.DiskOffering{
+		"fixed":  {ID: "disk-1", Name: "fixed", DiskSize: 100},
+		"custom": {ID: "disk-2", Name: "custom", IsCustomized: true},
+	}}, &fakeSSHKeyPairProvider{}, &fakeUserDataProvider{})
+}
+
+func TestValidateCreate(t *testing.T) {
+	valid := v1.CloudStackNodeClassSpec{
+		Zone:                "zone-a",
+		SSHKeyPair:          lo.ToPtr("karpenter"),
+		RootDiskOffering:    &v1.RootDiskOfferingSelector{Name: "fixed"},
+		DiskOffering:        lo.ToPtr("custom"),
+		DataDiskSize:        lo.ToPtr[int64](50),
+		BlockDeviceMappings: []v1.BlockDeviceMapping{{DiskOffering: "fixed"}},
+		Tags:                map[string]string{"team": "a"},
+	}
+	tests := []struct {
+		name string
+		spec v1.CloudStackNodeClassSpec
+		// wantFields are the fields reported invalid
+		wantFields []string
+	}{
+		{
+			name: "valid NodeClass",
+			spec: valid,
+		},
+		{
+			name: "every problem reported at once",
+			spec: v1.CloudStackNodeClassSpec{
+				Zone:                "missing",
+				SSHKeyPair:          lo.ToPtr("missing"),
+				RootDiskOffering:    &v1.RootDiskOfferingSelector{Name: "missing"},
+				DiskOffering:        lo.ToPtr("custom"),
+				BlockDeviceMappings: []v1.BlockDeviceMapping{{DiskOffering: "fixed"}, {DiskOffering: "missing"}},
+				UserData:            lo.ToPtr("invalid"),
+				Tags:                map[string]string{v1.ManagedByTagKey: "me", v1.ClusterNameTagKey + "/other": "owned"},
+			},
+			wantFields: []string{
+				"spec.blockDeviceMappings[1].diskOffering",
+				"spec.diskOffering",
+				"spec.rootDiskOffering",
+				"spec.sshKeyPair",
+				"spec.tags[" + v1.ManagedByTagKey + "]",
+				"spec.tags[" + v1.ClusterNameTagKey + "/other]",
+				"spec.userData",
+				"spec.zone",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeClass := &v1.CloudStackNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: tt.spec}
+
+			t.Run(options.ValidationModeDeny, func(t *testing.T) {
+				warnings, err := newTestValidator(options.ValidationModeDeny).ValidateCreate(context.Background(), nodeClass)
+				if len(warnings) != 0 {
+					t.Errorf("ValidateCreate() warnings = %v, want none", warnings)
+				}
+				if len(tt.wantFields) == 0 {
+					if err != nil {
+						t.Errorf("ValidateCreate() error = %v, want nil", err)
+					}
+					return
+				}
+				var statusErr *apierrors.StatusError
+				if !errors.As(err, &statusErr) || !apierrors.IsInvalid(err) {
+					t.Fatalf("ValidateCreate() error = %v, want an Invalid error", err)
+				}
+				fields := lo.Map(statusErr.ErrStatus.Details.Causes, func(c metav1.StatusCause, _ int) string { return c.Field })
+				if fields = slices.Sorted(slices.Values(fields)); !slices.Equal(fields, tt.wantFields) {
+					t.Errorf("ValidateCreate() invalid fields = %v, want %v", fields, tt.wantFields)
+				}
+			})
+
+			t.Run(options.ValidationModeWarn, func(t *testing.T) {
+				warnings, err := newTestValidator(options.ValidationModeWarn).ValidateCreate(context.Background(), nodeClass)
+				if err != nil {
+					t.Fatalf("ValidateCreate() error = %v, want nil", err)
+				}
+				if len(warnings) != len(tt.wantFields) {
+					t.Fatalf("ValidateCreate() warnings = %v, want one for each of %v", warnings, tt.wantFields)
+				}
+				for _, field := range tt.wantFields {
+					if !lo.ContainsBy(warnings, func(w string) bool { return strings.HasPrefix(w, field+":") }) {
+						t.Errorf("ValidateCreate() warnings = %v, want a warning for %s", warnings, field)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	invalid := v1.CloudStackNodeClassSpec{Zone: "missing"}
+	tests := []struct {
+		name     string
+		oldSpec  v1.CloudStackNodeClassSpec
+		spec     v1.CloudStackNodeClassSpec
+		deleting bool
+		wantErr  bool
+	}{
+		{
+			name:    "spec changed",
+			oldSpec: v1.CloudStackNodeClassSpec{Zone: "zone-a"},
+			spec:    invalid,
+			wantErr: true,
+		},
+		{
+			name:    "spec unchanged",
+			oldSpec: invalid,
+			spec:    invalid,
+		},
+		{
+			name:     "NodeClass being deleted",
+			oldSpec:  v1.CloudStackNodeClassSpec{Zone: "zone-a"},
+			spec:     invalid,
+			deleting: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldNodeClass := &v1.CloudStackNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: tt.oldSpec}
+			nodeClass := &v1.CloudStackNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: tt.spec}
+			if tt.deleting {
+				nodeClass.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			}
+			_, err := newTestValidator(options.ValidationModeDeny).ValidateUpdate(context.Background(), oldNodeClass, nodeClass)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
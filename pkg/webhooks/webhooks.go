@@ -0,0 +1,53 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	v1 "github.com/mperea/karpenter-provider-cloudstack/pkg/apis/v1"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/operator/options"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/diskoffering"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/sshkeypair"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/template"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/userdata"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/providers/zone"
+	"github.com/mperea/karpenter-provider-cloudstack/pkg/webhooks/nodeclass"
+)
+
+// Register serves the CloudStack admission webhooks from the manager webhook server, on the webhook
+// port with the certificate mounted in the default certificate directory
+func Register(
+	ctx context.Context,
+	mgr manager.Manager,
+	zoneProvider zone.Provider,
+	templateProvider template.Provider,
+	diskOfferingProvider diskoffering.Provider,
+	sshKeyPairProvider sshkeypair.Provider,
+	userDataProvider userdata.Provider,
+) error {
+	if server, ok := mgr.GetWebhookServer().(*webhook.DefaultServer); ok {
+		server.Options.Port = options.FromContext(ctx).WebhookPort
+	}
+	return controllerruntime.NewWebhookManagedBy(mgr).
+		For(&v1.CloudStackNodeClass{}).
+		WithDefaulter(nodeclass.NewDefaulter(templateProvider)).
+		WithValidator(nodeclass.NewValidator(ctx, zoneProvider, diskOfferingProvider, sshKeyPairProvider, userDataProvider)).
+		Complete()
+}